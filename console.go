@@ -0,0 +1,282 @@
+package djs
+
+// #include "duktape.h"
+// #include "duk_console.h"
+// #include "duk_print_alert.h"
+// extern void goConsoleWrite(duk_context *ctx, duk_int_t level, char *msg, duk_size_t len);
+// extern void goPrintAlertWrite(duk_context *ctx, duk_int_t isAlert, char *buf, duk_size_t len);
+import "C"
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ConsoleLevel is the level of the output of console methods.
+type ConsoleLevel int
+
+const (
+	LevelDebug ConsoleLevel = C.DUK_CONSOLE_LEVEL_DEBUG // console.debug(), console.trace()
+	LevelLog   ConsoleLevel = C.DUK_CONSOLE_LEVEL_LOG   // console.log(), console.dir()
+	LevelInfo  ConsoleLevel = C.DUK_CONSOLE_LEVEL_INFO  // console.info()
+	LevelWarn  ConsoleLevel = C.DUK_CONSOLE_LEVEL_WARN  // console.warn()
+	LevelError ConsoleLevel = C.DUK_CONSOLE_LEVEL_ERROR // console.error(), console.exception(), console.assert()
+)
+
+func (l ConsoleLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelLog:
+		return "log"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+func (l ConsoleLevel) slogLevel() slog.Level {
+	switch l {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// OutputEntry is the output of a call of console.xxx(), print() or alert().
+type OutputEntry struct {
+	Time time.Time
+	Source string // "console", "print" or "alert"
+	Level ConsoleLevel // LevelLog for print(), LevelWarn for alert()
+	Message string // without the trailing newline
+}
+
+// Output is the output captured by CaptureOutput() or EvalCapture().
+type Output []OutputEntry
+
+// String returns all the messages, one line for each entry.
+func (out Output) String() string {
+	var b strings.Builder
+	for _, e := range out {
+		b.WriteString(e.Message)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// output is where console.xxx(), print() and alert() of a context write to.
+type output struct {
+	console io.Writer
+	print io.Writer
+	logger *slog.Logger
+	minLevel ConsoleLevel
+}
+
+var defaultOutput = &output{}
+
+func newOutput(o *Options) *output {
+	if o.consoleWriter == nil && o.printWriter == nil && o.logger == nil && o.consoleLevel == LevelDebug {
+		return nil
+	}
+	return &output{
+		console: o.consoleWriter,
+		print: o.printWriter,
+		logger: o.logger,
+		minLevel: o.consoleLevel,
+	}
+}
+
+// writeConsole is called with the messages of levels not lower than minLevel.
+func (o *output) writeConsole(level ConsoleLevel, msg string) {
+	switch {
+	case o.console != nil:
+		fmt.Fprintln(o.console, msg)
+	case o.logger != nil:
+		o.logger.Log(context.Background(), level.slogLevel(), msg)
+	default:
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
+
+func (o *output) writePrint(isAlert bool, msg string) {
+	switch {
+	case o.print != nil:
+		io.WriteString(o.print, msg)
+	case o.logger != nil:
+		level := slog.LevelInfo
+		if isAlert {
+			level = slog.LevelWarn
+		}
+		o.logger.Log(context.Background(), level, strings.TrimSuffix(msg, "\n"))
+	case isAlert:
+		io.WriteString(os.Stderr, msg)
+	default:
+		io.WriteString(os.Stdout, msg)
+	}
+}
+
+// capture is the output captured by CaptureOutput(). Entries are added when the heap
+// is released, so they are guarded by mu.
+type capture struct {
+	mu sync.Mutex
+	out Output
+}
+
+func (c *capture) add(e OutputEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out = append(c.out, e)
+}
+
+var (
+	outputs = make(map[uintptr]*output) // duk context -> output
+	captures = make(map[uintptr][]*capture) // duk context -> captures in progress
+	outputsMu = &sync.RWMutex{}
+)
+
+func setOutput(ctx *C.duk_context, o *output) {
+	if o == nil {
+		return
+	}
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	outputs[uintptr(unsafe.Pointer(ctx))] = o
+}
+
+func delOutput(ctx uintptr) {
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	delete(outputs, ctx)
+	delete(captures, ctx)
+}
+
+func getOutput(ctx *C.duk_context) *output {
+	outputsMu.RLock()
+	defer outputsMu.RUnlock()
+	if o, ok := outputs[uintptr(unsafe.Pointer(ctx))]; ok {
+		return o
+	}
+	return defaultOutput
+}
+
+// captureOutput captures all output of ctx until the returned stop is called.
+func captureOutput(ctx *C.duk_context) (stop func() Output) {
+	c := &capture{}
+	key := uintptr(unsafe.Pointer(ctx))
+	outputsMu.Lock()
+	captures[key] = append(captures[key], c)
+	outputsMu.Unlock()
+
+	return func() Output {
+		outputsMu.Lock()
+		cs := captures[key]
+		for i := range cs {
+			if cs[i] == c {
+				cs = append(cs[:i:i], cs[i+1:]...)
+				break
+			}
+		}
+		if len(cs) > 0 {
+			captures[key] = cs
+		} else {
+			delete(captures, key)
+		}
+		outputsMu.Unlock()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.out
+	}
+}
+
+// captureEntry adds e to the captures in progress of ctx, it reports false if the
+// output of ctx is not being captured.
+func captureEntry(ctx *C.duk_context, e OutputEntry) bool {
+	outputsMu.RLock()
+	cs := captures[uintptr(unsafe.Pointer(ctx))]
+	outputsMu.RUnlock()
+	for _, c := range cs {
+		c.add(e)
+	}
+	return len(cs) > 0
+}
+
+// CaptureOutput calls fn and captures the output of console.xxx(), print() and alert()
+// of the context during the call, instead of writing them to the normal destination.
+// Methods of the context can be called in fn. The output of the context during the call
+// is captured even if it's called by other goroutines.
+func (ctx *JsContext) CaptureOutput(fn func() error) (out Output, err error) {
+	ctx.mu.Lock()
+	if err = ctx.checkOpen(); err != nil {
+		ctx.mu.Unlock()
+		return
+	}
+	stop := captureOutput(ctx.c)
+	ctx.mu.Unlock()
+
+	err = fn()
+	out = stop()
+	return
+}
+
+// EvalCapture is the same as Eval, and returns the output of console.xxx(), print()
+// and alert() during the evaluation.
+func (ctx *JsContext) EvalCapture(script string, env map[string]interface{}) (res interface{}, out Output, err error) {
+	out, err = ctx.CaptureOutput(func() (e error) {
+		res, e = ctx.Eval(script, env)
+		return
+	})
+	return
+}
+
+//export goConsoleWrite
+func goConsoleWrite(ctx *C.duk_context, level C.duk_int_t, msg *C.char, length C.duk_size_t) {
+	s := C.GoStringN(msg, C.int(length))
+	withHeapReleased(ctx, func() {
+		o := getOutput(ctx)
+		if ConsoleLevel(level) < o.minLevel {
+			// neither written nor captured
+			return
+		}
+		if captureEntry(ctx, OutputEntry{Time: time.Now(), Source: "console", Level: ConsoleLevel(level), Message: s}) {
+			return
+		}
+		o.writeConsole(ConsoleLevel(level), s)
+	})
+}
+
+//export goPrintAlertWrite
+func goPrintAlertWrite(ctx *C.duk_context, isAlert C.duk_int_t, buf *C.char, length C.duk_size_t) {
+	s := C.GoStringN(buf, C.int(length))
+	withHeapReleased(ctx, func() {
+		e := OutputEntry{Time: time.Now(), Source: "print", Level: LevelLog, Message: strings.TrimSuffix(s, "\n")}
+		if isAlert != 0 {
+			e.Source, e.Level = "alert", LevelWarn
+		}
+		if captureEntry(ctx, e) {
+			return
+		}
+		getOutput(ctx).writePrint(isAlert != 0, s)
+	})
+}
+
+func init() {
+	C.duk_console_set_writer((C.duk_console_writer)(C.goConsoleWrite))
+	C.duk_print_alert_set_writer((C.duk_print_alert_writer)(C.goPrintAlertWrite))
+}
@@ -0,0 +1,111 @@
+package djs
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvalCapture(t *testing.T) {
+	consoleBuf := &bytes.Buffer{}
+	ctx, err := NewContext(WithConsoleWriter(consoleBuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	done := make(chan struct{})
+	var res interface{}
+	var out Output
+	go func() {
+		defer close(done)
+		res, out, err = ctx.EvalCapture("console.log('hi'); console.warn('careful'); print('printed'); alert('alerted'); 1", nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("EvalCapture() doesn't return")
+	}
+	if err != nil || res != float64(1) {
+		t.Fatalf("EvalCapture() = %v, %v", res, err)
+	}
+	want := []OutputEntry{
+		{Source: "console", Level: LevelLog, Message: "hi"},
+		{Source: "console", Level: LevelWarn, Message: "careful"},
+		{Source: "print", Level: LevelLog, Message: "printed"},
+		{Source: "alert", Level: LevelWarn, Message: "alerted"},
+	}
+	if len(out) != len(want) {
+		t.Fatalf("captured %v, want %v", out, want)
+	}
+	for i, e := range out {
+		if e.Source != want[i].Source || e.Level != want[i].Level || e.Message != want[i].Message || e.Time.IsZero() {
+			t.Fatalf("entry %d is %+v, want %+v", i, e, want[i])
+		}
+	}
+	if consoleBuf.Len() != 0 {
+		t.Fatalf("captured output is written to the writer: %q", consoleBuf.String())
+	}
+
+	// not captured any more
+	if _, err = ctx.Eval("console.log('after')", nil); err != nil {
+		t.Fatal(err)
+	}
+	if consoleBuf.String() != "after\n" {
+		t.Fatalf("output after capturing is %q", consoleBuf.String())
+	}
+}
+
+func TestCaptureOutputConcurrently(t *testing.T) {
+	ctx, err := NewContext(WithIsolatedGlobalEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := ctx.CaptureOutput(func() error {
+				for j := 0; j < 10; j++ {
+					if _, err := ctx.Eval("console.log('x')", nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// output of other goroutines during the call may be captured too
+			if len(out) < 10 {
+				t.Errorf("%d entries captured, want at least 10", len(out))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEvalCaptureLevel(t *testing.T) {
+	ctx, err := NewContext(WithConsoleLevel(LevelWarn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	_, out, err := ctx.EvalCapture("console.debug('d'); console.log('l'); console.warn('w'); console.error('e'); print('p')", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var levels []ConsoleLevel
+	for _, e := range out {
+		levels = append(levels, e.Level)
+	}
+	if len(levels) != 3 || levels[0] != LevelWarn || levels[1] != LevelError || levels[2] != LevelLog || out[2].Source != "print" {
+		t.Fatalf("captured %v, want the output not lower than LevelWarn and print()", out)
+	}
+}
@@ -6,100 +6,365 @@ package djs
 #include "duk_console.h"
 #include "duk_print_alert.h"
 #include "duk_module_duktape.h"
-static duk_context *createContext() {
-	return duk_create_heap_default();
+#include "djs_heap.h"
+static duk_context *createContext(size_t memLimit) {
+	return djs_create_heap(memLimit);
 }
-static const char *getCString(duk_context *ctx, duk_idx_t idx) {
+static void getMemoryUsage(duk_context *ctx, size_t *used, size_t *peak) {
+	djs_heap_udata *udata = djs_get_heap_udata(ctx);
+	*used = udata->mem_used;
+	*peak = udata->mem_peak;
+}
+const char *getCString(duk_context *ctx, duk_idx_t idx) {
 	return duk_safe_to_string(ctx, idx);
 }
-static duk_int_t pEval(duk_context *ctx, const char *src, duk_size_t len) {
-	return duk_peval_lstring(ctx, src, len);
+static duk_int_t pEval(duk_context *ctx, const char *src, duk_size_t len, const char *filename, duk_size_t filenameLen) {
+	if (filenameLen == 0) {
+		return duk_peval_lstring(ctx, src, len);
+	}
+	duk_push_lstring(ctx, filename, filenameLen);
+	if (duk_pcompile_lstring_filename(ctx, DUK_COMPILE_EVAL, src, len) != 0) {
+		return DUK_EXEC_ERROR;
+	}
+	duk_push_global_object(ctx);
+	return duk_pcall_method(ctx, 0);
+}
+static duk_ret_t putProp(duk_context *ctx, void *udata) {
+	// [ obj key value ]
+	(void) udata;
+	duk_put_prop(ctx, -3);
+	return 0;
+}
+duk_int_t pPutProp(duk_context *ctx) {
+	return duk_safe_call(ctx, putProp, NULL, 3, 1);
 }
 */
 import "C"
 import (
+	"context"
 	"reflect"
 	"unsafe"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"runtime"
+	"time"
+)
+
+var (
+	ErrContextClosed = errors.New("context closed")
 )
 
 var (
 	globalHeap *C.duk_context
+	globalHeapLock *heapLock // serializes the execution of all contexts in the global heap
 	globalMu = &sync.Mutex{}
-	preludeLoaded = false
+	globalHeapGen uint32 // increased every time the global heap is destroyed
+	globalThreads = make(map[uint32]uintptr) // thread id -> thread context
+	threadIdx uint32
 )
 
-func init() {
-	globalHeap = C.createContext()
+// initGlobalHeap creates the global heap if it doesn't exist. globalMu must be held.
+func initGlobalHeap(moduleHome string) error {
+	if globalHeap != nil {
+		return nil
+	}
+	heap := C.createContext(0)
+	if heap == nil {
+		return fmt.Errorf("failed to init duktape heap")
+	}
+	loadPreludeModules(heap, moduleHome)
+	globalHeap = heap
+	globalHeapLock = newHeapLock(heap)
+	return nil
+}
+
+// lockGlobalHeap creates the global heap if it doesn't exist, and locks it.
+func lockGlobalHeap(moduleHome string) (mu *heapLock, heapGen uint32, err error) {
+	for {
+		globalMu.Lock()
+		if err = initGlobalHeap(moduleHome); err != nil {
+			globalMu.Unlock()
+			return
+		}
+		mu, heapGen = globalHeapLock, globalHeapGen
+		globalMu.Unlock()
+
+		mu.Lock()
+		if atomic.LoadUint32(&globalHeapGen) == heapGen {
+			if mu.fatal {
+				mu.Unlock()
+				err = ErrHeapFatal
+			}
+			return
+		}
+		// the heap was destroyed when waiting for the lock
+		mu.Unlock()
+	}
+}
+
+// destroyGlobalHeap frees the global heap and invalidates all the contexts
+// created on it. globalMu and globalHeapLock must be held.
+func destroyGlobalHeap() {
 	if globalHeap == nil {
-		panic("failed to init duktape heap")
+		return
+	}
+	delHeapLock(globalHeap)
+	if !globalHeapLock.fatal {
+		// a broken heap is leaked rather than touched
+		C.djs_destroy_heap(globalHeap)
 	}
+	delPtrStore(uintptr(unsafe.Pointer(globalHeap)))
+	for threadId, thread := range globalThreads {
+		delCtxRef(thread)
+		delPtrStore(thread)
+		delOutput(thread)
+		delModuleConf(thread)
+		delete(globalThreads, threadId)
+	}
+	globalHeap = nil
+	globalHeapLock = nil
+	atomic.AddUint32(&globalHeapGen, 1)
 }
 
-/*
-func DestroyJsHeap() {
+// DestroyJsHeap destroys the global heap shared by contexts created with
+// WithGlobalHeap(). All these contexts are closed, and the next NewContext(WithGlobalHeap())
+// will create a new global heap. It returns ErrHeapBusy if any script in the global heap
+// is running, i.e. it's calling a Go function. After a fatal error of Duktape, the global heap
+// must be destroyed before creating new contexts with WithGlobalHeap().
+func DestroyJsHeap() error {
+	globalMu.Lock()
+	mu := globalHeapLock
+	globalMu.Unlock()
+	if mu == nil {
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if mu.busy() {
+		return ErrHeapBusy
+	}
+
 	globalMu.Lock()
 	defer globalMu.Unlock()
+	if globalHeapLock == mu {
+		destroyGlobalHeap()
+	}
+	return nil
+}
 
-	if globalHeap != nil {
-		C.duk_destroy_heap(globalHeap)
-		globalHeap = nil
+// ResetJsHeap destroys the global heap and recreates it immediately.
+func ResetJsHeap(options ...Option) error {
+	if err := DestroyJsHeap(); err != nil {
+		return err
 	}
-}*/
+
+	o := getOptions(options...)
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	return initGlobalHeap(o.moduleHome)
+}
+
+// newGlobalThread creates a thread in the global heap. The thread is referenced
+// by the heap stash so that it can be released by id. If newGlobalEnv is true,
+// the thread has its own global object and built-ins. globalMu and globalHeapLock
+// must be held.
+func newGlobalThread(newGlobalEnv bool) (ctx *C.duk_context, threadId uint32) {
+	var flags C.duk_uint_t
+	if newGlobalEnv {
+		flags = C.DUK_THREAD_NEW_GLOBAL_ENV
+	}
+	C.duk_push_heap_stash(globalHeap) // [ stash ]
+	C.duk_push_thread_raw(globalHeap, flags) // [ stash thread ]
+	ctx = C.duk_get_context(globalHeap, -1)
+	threadIdx += 1
+	threadId = threadIdx
+	C.duk_put_prop_index(globalHeap, -2, C.duk_uarridx_t(threadId)) // [ stash ] with stash[threadId] = thread
+	C.duk_pop(globalHeap) // [ ]
+	globalThreads[threadId] = uintptr(unsafe.Pointer(ctx))
+	return
+}
+
+// freeGlobalThread releases the thread referenced by the heap stash. globalMu and
+// globalHeapLock must be held.
+func freeGlobalThread(threadId uint32) {
+	C.duk_push_heap_stash(globalHeap) // [ stash ]
+	C.duk_del_prop_index(globalHeap, -1, C.duk_uarridx_t(threadId)) // [ stash ]
+	C.duk_pop(globalHeap) // [ ]
+	delete(globalThreads, threadId)
+}
 
 type JsContext struct {
 	c *C.duk_context
-	mu *sync.Mutex
+	mu *heapLock // shared by all contexts in the same heap
 	withGlobalHeap bool
+	threadId uint32
+	heapGen uint32
+	execTimeout time.Duration
+	exec *C.djs_exec_state // set to the heap when the context is entered
+	modConf *moduleConf
 }
 
 func NewContext(options ...Option) (*JsContext, error) {
 	o := getOptions(options...)
-	globalMu.Lock()
-	if !preludeLoaded {
-		loadPreludeModules(globalHeap, o.moduleHome)
-		preludeLoaded = true
-	}
-	globalMu.Unlock()
 
 	var ctx *C.duk_context
+	var threadId, heapGen uint32
+	var mu *heapLock
 
+	exec := C.djs_new_exec_state()
+	if exec == nil {
+		return nil, fmt.Errorf("failed to create context")
+	}
 	withGlobalHeap := o.withGlobalHeap
 	if withGlobalHeap {
+		var err error
+		if mu, heapGen, err = lockGlobalHeap(o.moduleHome); err != nil {
+			C.djs_free_exec_state(exec)
+			return nil, err
+		}
+		defer mu.Unlock()
 		globalMu.Lock()
-		defer globalMu.Unlock()
-		C.duk_push_thread_raw(globalHeap, 0)
-		ctx = C.duk_get_context(globalHeap, -1)
+		ctx, threadId = newGlobalThread(o.newGlobalEnv)
+		globalMu.Unlock()
 	} else {
-		ctx = C.createContext()
+		if ctx = C.createContext(C.size_t(o.memoryLimit)); ctx != nil {
+			mu = newHeapLock(ctx)
+		}
 	}
 	if ctx == (*C.duk_context)(unsafe.Pointer(nil)) {
+		C.djs_free_exec_state(exec)
 		return nil, fmt.Errorf("failed to create context")
 	}
 
-	if !withGlobalHeap {
+	C.djs_set_current(ctx, exec)
+	if !withGlobalHeap || o.newGlobalEnv {
 		loadPreludeModules(ctx, o.moduleHome)
 	}
+	if !withGlobalHeap {
+		if err := checkMemoryLimit(ctx); err != nil {
+			delHeapLock(ctx)
+			C.djs_destroy_heap(ctx)
+			C.djs_free_exec_state(exec)
+			return nil, err
+		}
+	}
 	registerGoProxyHandlers(ctx)
+	setCtxRef(uintptr(unsafe.Pointer(ctx)), mu, exec)
+	setOutput(ctx, newOutput(o))
+	modConf := setModuleConf(ctx, o)
 	c := &JsContext {
 		c: ctx,
-		mu: &sync.Mutex{},
+		mu: mu,
 		withGlobalHeap: withGlobalHeap,
+		threadId: threadId,
+		heapGen: heapGen,
+		execTimeout: o.execTimeout,
+		exec: exec,
+		modConf: modConf,
 	}
 	runtime.SetFinalizer(c, freeJsContext)
 	return c, nil
 }
 
 func freeJsContext(ctx *JsContext) {
+	ctx.Close()
+}
+
+// Close frees the heap of the context, or the thread if the context is created
+// with WithGlobalHeap(). All Go values referenced by the context are released,
+// and any later calls of the context will return ErrContextClosed. ErrHeapBusy
+// is returned if a script of the context is running, i.e. it's calling a Go function.
+// After a fatal error of Duktape, other calls return ErrHeapFatal, and the heap of the
+// context is not freed by Close(), as its state is broken.
+func (ctx *JsContext) Close() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
 	c := ctx.c
-	if (!ctx.withGlobalHeap) {
-		C.duk_destroy_heap(c)
+	if c == nil {
+		return nil
+	}
+	if ctx.withGlobalHeap && ctx.mu.runningIn(uintptr(unsafe.Pointer(c))) || !ctx.withGlobalHeap && ctx.mu.busy() {
+		return ErrHeapBusy
+	}
+	ctx.c = nil
+	runtime.SetFinalizer(ctx, nil)
+	defer C.djs_free_exec_state(ctx.exec)
+
+	if ctx.withGlobalHeap {
+		globalMu.Lock()
+		defer globalMu.Unlock()
+		if globalHeap == nil || ctx.heapGen != globalHeapGen {
+			// already freed by destroyGlobalHeap()
+			return nil
+		}
+		if ctx.mu.fatal {
+			delete(globalThreads, ctx.threadId)
+		} else {
+			if current := C.djs_set_current(c, nil); current != ctx.exec {
+				C.djs_set_current(c, current)
+			}
+			freeGlobalThread(ctx.threadId)
+		}
+	} else {
+		delHeapLock(c)
+		if !ctx.mu.fatal {
+			// a broken heap is leaked rather than touched
+			C.djs_destroy_heap(c)
+		}
 	}
+	delCtxRef(uintptr(unsafe.Pointer(c)))
 	delPtrStore((uintptr(unsafe.Pointer(c))))
+	delOutput(uintptr(unsafe.Pointer(c)))
+	delModuleConf(uintptr(unsafe.Pointer(c)))
 	// fmt.Printf("context freed\n")
+	return nil
+}
+
+// MemoryUsage returns the bytes currently allocated by the heap of the context and
+// the peak value. For contexts created with WithGlobalHeap(), the usage of the shared
+// global heap is returned.
+func (ctx *JsContext) MemoryUsage() (current, peak uint64) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.checkOpen() != nil {
+		return
+	}
+	var used, maxUsed C.size_t
+	C.getMemoryUsage(ctx.c, &used, &maxUsed)
+	return uint64(used), uint64(maxUsed)
+}
+
+func (ctx *JsContext) isOpen() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.checkOpen() == nil
+}
+
+// checkOpen must be called with ctx.mu held. If a script of the context is calling a Go
+// function from another goroutine, it waits until the Go function returns. The context is
+// entered if it's open, i.e. the execution timeout check of the heap checks its state.
+func (ctx *JsContext) checkOpen() error {
+	if ctx.c != nil {
+		ctx.mu.enter(uintptr(unsafe.Pointer(ctx.c)))
+	}
+	if ctx.c == nil {
+		return ErrContextClosed
+	}
+	if ctx.withGlobalHeap && ctx.heapGen != atomic.LoadUint32(&globalHeapGen) {
+		return ErrContextClosed
+	}
+	if ctx.mu.fatal {
+		return ErrHeapFatal
+	}
+	C.djs_set_current(ctx.c, ctx.exec)
+	return nil
 }
 
 func loadPreludeModules(ctx *C.duk_context, moduleHome string) {
@@ -110,13 +375,37 @@ func loadPreludeModules(ctx *C.duk_context, moduleHome string) {
 }
 
 func (ctx *JsContext) Eval(script string, env map[string]interface{}) (res interface{}, err error) {
+	return ctx.EvalContext(context.Background(), script, env)
+}
+
+// EvalContext is the same as Eval, but the script is aborted with a *TimeoutError
+// when goCtx is cancelled or its deadline passes.
+func (ctx *JsContext) EvalContext(goCtx context.Context, script string, env map[string]interface{}) (res interface{}, err error) {
+	return ctx.EvalNamedContext(goCtx, "", script, env)
+}
+
+// EvalNamed is the same as Eval, name is used as the file name of the script
+// in error messages and stack traces.
+func (ctx *JsContext) EvalNamed(name string, script string, env map[string]interface{}) (res interface{}, err error) {
+	return ctx.EvalNamedContext(context.Background(), name, script, env)
+}
+
+// EvalNamedContext is the same as EvalNamed, but the script is aborted with a *TimeoutError
+// when goCtx is cancelled or its deadline passes.
+func (ctx *JsContext) EvalNamedContext(goCtx context.Context, name string, script string, env map[string]interface{}) (res interface{}, err error) {
 	var cstr *C.char
 	var length C.int
 	getStrPtrLen(&script, &cstr, &length)
-	return ctx.eval(cstr, length, env)
+	return ctx.eval(goCtx, name, "", cstr, length, env, false)
 }
 
 func (ctx *JsContext) EvalFile(scriptFile string, env map[string]interface{}) (res interface{}, err error) {
+	return ctx.EvalFileContext(context.Background(), scriptFile, env)
+}
+
+// EvalFileContext is the same as EvalFile, but the script is aborted with a *TimeoutError
+// when goCtx is cancelled or its deadline passes.
+func (ctx *JsContext) EvalFileContext(goCtx context.Context, scriptFile string, env map[string]interface{}) (res interface{}, err error) {
 	b, e := os.ReadFile(scriptFile)
 	if e != nil {
 		err = e
@@ -126,22 +415,71 @@ func (ctx *JsContext) EvalFile(scriptFile string, env map[string]interface{}) (r
 	var length C.int
 	getBytesPtrLen(b, &cstr, &length)
 
-	return ctx.eval(cstr, length, env)
+	return ctx.eval(goCtx, scriptFile, ctx.entryFile(scriptFile), cstr, length, env, false)
 }
 
-func (ctx *JsContext) eval(script *C.char, scriptLen C.int, env map[string]interface{}) (res interface{}, err error) {
+// entryFile returns the path of the script file from which the modules required out of
+// modules are resolved: the absolute path for the default loader, or the path as it is
+// for the loader set by WithModuleLoader().
+func (ctx *JsContext) entryFile(scriptFile string) string {
+	if ctx.modConf.loader != nil {
+		return scriptFile
+	}
+	if p, err := filepath.Abs(scriptFile); err == nil {
+		return p
+	}
+	return scriptFile
+}
+
+// if entry is not empty, it's the script file evaluated, see setEntryFile().
+// if scoped is true, env is only visible during the evaluation.
+func (ctx *JsContext) eval(goCtx context.Context, name string, entry string, script *C.char, scriptLen C.int, env map[string]interface{}, scoped bool) (res interface{}, err error) {
 	ctx.mu.Lock()
 	defer ctx.mu.Unlock()
 
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
 	c := ctx.c
-	setEnv(c, env)
+	if len(entry) > 0 {
+		ctx.modConf.setEntryFile(entry)
+	}
+	if scoped {
+		var restoreEnv func() error
+		if restoreEnv, err = setScopedEnv(c, env); err != nil {
+			return
+		}
+		defer func() {
+			if e := restoreEnv(); e != nil && err == nil {
+				err = e
+			}
+		}()
+	} else if err = setEnv(c, env); err != nil {
+		return
+	}
+	if err = checkMemoryLimit(c); err != nil {
+		return
+	}
 
-	if C.pEval(c, script, C.size_t(scriptLen)) != 0 { // [ result ]
-		estr := C.getCString(c, -1)
-		C.duk_pop(c)
-		err = fmt.Errorf("%s", C.GoString(estr))
+	goCtx, cancel := ctx.execContext(goCtx)
+	defer cancel()
+	stop, err := ctx.watchInterrupt(goCtx)
+	if err != nil {
+		return
+	}
+
+	var cName *C.char
+	var nameLen C.int
+	getStrPtrLen(&name, &cName, &nameLen)
+	restore := limitMemory(c, true)
+	rc := C.pEval(c, script, C.size_t(scriptLen), cName, C.size_t(nameLen))
+	restore()
+	if rc != 0 { // [ error ]
+		err = stop(memoryLimitError(c, getJsError(c)))
+		C.duk_pop(c) // [ ]
 		return
 	}
+	stop(nil)
 
 	defer C.duk_pop(c)
 	return fromJsValue(c)
@@ -156,16 +494,22 @@ func dump(ctx *C.duk_context, prompt string) {
 	fmt.Printf("--- %s END ---\n", prompt)
 }*/
 
-func setEnv(ctx *C.duk_context, env map[string]interface{}) {
-	C.duk_push_global_object(ctx) // [ global ]
-	defer C.duk_pop(ctx) // [ ]
-
+// setEnv sets env as global vars. The vars are set in protected calls, as setting a
+// read-only var throws an error.
+func setEnv(ctx *C.duk_context, env map[string]interface{}) error {
 	for k, _ := range env {
 		v := env[k]
+		C.duk_push_global_object(ctx) // [ global ]
 		pushString(ctx, k)  // [ global k ]
 		pushJsProxyValue(ctx, v)  // [ global k v ]
-		C.duk_put_prop(ctx, -3) // [ global ] with global[k] = v
+		if C.pPutProp(ctx) != 0 { // [ error ]
+			err := fmt.Errorf("failed to set env %s: %w", k, getJsError(ctx))
+			C.duk_pop(ctx) // [ ]
+			return err
+		}
+		C.duk_pop(ctx) // [ ] with global[k] = v
 	}
+	return nil
 }
 
 func getVar(ctx *C.duk_context, name string) (exsiting bool) {
@@ -180,6 +524,9 @@ func (ctx *JsContext) GetGlobal(name string) (res interface{}, err error) {
 	ctx.mu.Lock()
 	defer ctx.mu.Unlock()
 
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
 	c := ctx.c
 	C.duk_push_global_object(c) // [ global ]
 	defer C.duk_pop_n(c, 2) // [ ]
@@ -192,9 +539,18 @@ func (ctx *JsContext) GetGlobal(name string) (res interface{}, err error) {
 }
 
 func (ctx *JsContext) CallFunc(funcName string, args ...interface{}) (res interface{}, err error) {
+	return ctx.CallFuncContext(context.Background(), funcName, args...)
+}
+
+// CallFuncContext is the same as CallFunc, but the function is aborted with a *TimeoutError
+// when goCtx is cancelled or its deadline passes.
+func (ctx *JsContext) CallFuncContext(goCtx context.Context, funcName string, args ...interface{}) (res interface{}, err error) {
 	ctx.mu.Lock()
 	defer ctx.mu.Unlock()
 
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
 	c := ctx.c
 
 	C.duk_push_global_object(c) // [ global ]
@@ -210,7 +566,16 @@ func (ctx *JsContext) CallFunc(funcName string, args ...interface{}) (res interf
 		return
 	}
 
-	callFunc(c, args...) // [ global retval ]
+	goCtx, cancel := ctx.execContext(goCtx)
+	defer cancel()
+	stop, err := ctx.watchInterrupt(goCtx)
+	if err != nil {
+		return
+	}
+	if err = stop(callFunc(c, args...)); err != nil { // [ global error ]
+		return
+	}
+	// [ global retval ]
 	return fromJsValue(c)
 }
 
@@ -218,6 +583,12 @@ func (ctx *JsContext) CallFunc(funcName string, args ...interface{}) (res interf
 // is just calling the related golang func.
 // @param funcVarPtr  in format `var funcVar func(....) ...; funcVarPtr = &funcVar`
 func (ctx *JsContext) BindFunc(funcName string, funcVarPtr interface{}) (err error) {
+	return ctx.BindFuncContext(context.Background(), funcName, funcVarPtr)
+}
+
+// BindFuncContext is the same as BindFunc, but calling the bound func will be aborted
+// with a *TimeoutError when goCtx is cancelled or its deadline passes.
+func (ctx *JsContext) BindFuncContext(goCtx context.Context, funcName string, funcVarPtr interface{}) (err error) {
 	if funcVarPtr == nil {
 		err = fmt.Errorf("funcVarPtr must be a non-nil poiter of func")
 		return
@@ -231,6 +602,9 @@ func (ctx *JsContext) BindFunc(funcName string, funcVarPtr interface{}) (err err
 	ctx.mu.Lock()
 	defer ctx.mu.Unlock()
 
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
 	c := ctx.c
 
 	C.duk_push_global_object(c) // [ global ]
@@ -247,7 +621,7 @@ func (ctx *JsContext) BindFunc(funcName string, funcVarPtr interface{}) (err err
 	}
 
 	C.duk_pop_n(c, 2) // [ ] function will be restored when calling
-	return bindFunc(ctx, funcName, funcVarPtr)
+	return bindFunc(ctx, goCtx, funcName, funcVarPtr)
 }
 
 func (ctx *JsContext) BindFuncs(funcName2FuncVarPtr map[string]interface{}) (err error) {
@@ -0,0 +1,203 @@
+/*
+ *  Heap creation with user data used by the Go side.
+ */
+
+#include <stdlib.h>
+#include <stddef.h>
+#include <string.h>
+#include "duktape.h"
+#include "djs_heap.h"
+#if defined(_WIN32)
+#include <windows.h>
+#else
+#include <pthread.h>
+#endif
+
+/* Every block is prefixed with a header remembering its size, so that the
+ * usage can be accounted when the block is reallocated or freed.
+ */
+typedef union {
+	size_t size;
+	max_align_t align;
+} djs__alloc_hdr;
+
+static void djs__account(djs_heap_udata *u, size_t old_size, size_t new_size) {
+	u->mem_used = u->mem_used - old_size + new_size;
+	if (u->mem_used > u->mem_peak) {
+		u->mem_peak = u->mem_used;
+	}
+}
+
+static int djs__exceed_limit(djs_heap_udata *u, size_t old_size, size_t new_size) {
+	if (u->mem_limit == 0 || !u->mem_limited || new_size <= old_size) {
+		return 0;
+	}
+	if (u->mem_used - old_size + new_size > u->mem_limit) {
+		u->mem_limit_hit = 1;
+		return 1;
+	}
+	return 0;
+}
+
+static void *djs__alloc(void *udata, duk_size_t size) {
+	djs_heap_udata *u = (djs_heap_udata *) udata;
+	djs__alloc_hdr *hdr;
+
+	if (size == 0) {
+		return NULL;
+	}
+	if (djs__exceed_limit(u, 0, size)) {
+		return NULL;
+	}
+	hdr = (djs__alloc_hdr *) malloc(sizeof(djs__alloc_hdr) + size);
+	if (hdr == NULL) {
+		return NULL;
+	}
+	hdr->size = size;
+	djs__account(u, 0, size);
+	return (void *) (hdr + 1);
+}
+
+static void djs__free(void *udata, void *ptr) {
+	djs_heap_udata *u = (djs_heap_udata *) udata;
+	djs__alloc_hdr *hdr;
+
+	if (ptr == NULL) {
+		return;
+	}
+	hdr = ((djs__alloc_hdr *) ptr) - 1;
+	djs__account(u, hdr->size, 0);
+	free((void *) hdr);
+}
+
+static void *djs__realloc(void *udata, void *ptr, duk_size_t size) {
+	djs_heap_udata *u = (djs_heap_udata *) udata;
+	djs__alloc_hdr *hdr, *new_hdr;
+	size_t old_size;
+
+	if (ptr == NULL) {
+		return djs__alloc(udata, size);
+	}
+	if (size == 0) {
+		djs__free(udata, ptr);
+		return NULL;
+	}
+
+	hdr = ((djs__alloc_hdr *) ptr) - 1;
+	old_size = hdr->size;
+	if (djs__exceed_limit(u, old_size, size)) {
+		return NULL;
+	}
+	new_hdr = (djs__alloc_hdr *) realloc((void *) hdr, sizeof(djs__alloc_hdr) + size);
+	if (new_hdr == NULL) {
+		return NULL;
+	}
+	new_hdr->size = size;
+	djs__account(u, old_size, size);
+	return (void *) (new_hdr + 1);
+}
+
+/* implemented in Go */
+extern void goFatalHandler(void *udata, char *msg);
+
+static void djs__fatal(void *udata, const char *msg) {
+	goFatalHandler(udata, (char *) msg);
+}
+
+duk_bool_t djs_exec_timeout_check(void *udata) {
+	djs_heap_udata *u = (djs_heap_udata *) udata;
+	if (u == NULL || u->current == NULL) {
+		return 0;
+	}
+	return __atomic_load_n(&u->current->interrupted, __ATOMIC_RELAXED) != 0;
+}
+
+duk_context *djs_create_heap(size_t mem_limit) {
+	djs_heap_udata *udata;
+	duk_context *ctx;
+
+	udata = (djs_heap_udata *) malloc(sizeof(djs_heap_udata));
+	if (udata == NULL) {
+		return NULL;
+	}
+	memset((void *) udata, 0, sizeof(djs_heap_udata));
+	udata->mem_limit = mem_limit;
+
+	ctx = duk_create_heap(djs__alloc, djs__realloc, djs__free, (void *) udata, djs__fatal);
+	if (ctx == NULL) {
+		free((void *) udata);
+		return NULL;
+	}
+	return ctx;
+}
+
+djs_heap_udata *djs_get_heap_udata(duk_context *ctx) {
+	duk_memory_functions funcs;
+
+	duk_get_memory_functions(ctx, &funcs);
+	return (djs_heap_udata *) funcs.udata;
+}
+
+void djs_destroy_heap(duk_context *ctx) {
+	djs_heap_udata *udata = djs_get_heap_udata(ctx);
+
+	duk_destroy_heap(ctx);
+	free((void *) udata);
+}
+
+djs_exec_state *djs_new_exec_state(void) {
+	djs_exec_state *state = (djs_exec_state *) malloc(sizeof(djs_exec_state));
+	if (state != NULL) {
+		memset((void *) state, 0, sizeof(djs_exec_state));
+	}
+	return state;
+}
+
+void djs_free_exec_state(djs_exec_state *state) {
+	free((void *) state);
+}
+
+djs_exec_state *djs_set_current(duk_context *ctx, djs_exec_state *state) {
+	djs_heap_udata *udata = djs_get_heap_udata(ctx);
+	djs_exec_state *old;
+	if (udata == NULL) {
+		return NULL;
+	}
+	old = udata->current;
+	udata->current = state;
+	return old;
+}
+
+void djs_set_interrupted(djs_exec_state *state, int interrupted) {
+	if (state == NULL) {
+		return;
+	}
+	__atomic_store_n(&state->interrupted, interrupted, __ATOMIC_RELAXED);
+}
+
+int djs_set_mem_limited(duk_context *ctx, int limited) {
+	djs_heap_udata *udata = djs_get_heap_udata(ctx);
+	int old = udata->mem_limited;
+
+	udata->mem_limited = limited;
+	if (limited) {
+		udata->mem_limit_hit = 0;
+	}
+	return old;
+}
+
+int djs_take_mem_limit_hit(duk_context *ctx) {
+	djs_heap_udata *udata = djs_get_heap_udata(ctx);
+	int hit = udata->mem_limit_hit;
+
+	udata->mem_limit_hit = 0;
+	return hit;
+}
+
+uintptr_t djs_thread_id(void) {
+#if defined(_WIN32)
+	return (uintptr_t) GetCurrentThreadId();
+#else
+	return (uintptr_t) pthread_self();
+#endif
+}
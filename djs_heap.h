@@ -0,0 +1,65 @@
+#if !defined(DJS_HEAP_H_INCLUDED)
+#define DJS_HEAP_H_INCLUDED
+
+#include <stdint.h>
+#include "duktape.h"
+
+#if defined(__cplusplus)
+extern "C" {
+#endif
+
+/* Per-context execution state, a heap has many contexts if they are threads of it. */
+typedef struct djs_exec_state {
+	volatile int interrupted;  /* non-zero to abort the running script of the context */
+} djs_exec_state;
+
+/* Per-heap user data, shared by all the threads of a heap. */
+typedef struct djs_heap_udata {
+	djs_exec_state *current;   /* state of the context entered, NULL if unknown */
+	size_t mem_limit;          /* max bytes can be allocated, 0 for no limit */
+	size_t mem_used;           /* bytes allocated currently */
+	size_t mem_peak;           /* max value of mem_used */
+	int mem_limited;           /* non-zero if mem_limit is enforced */
+	int mem_limit_hit;         /* set when an allocation is refused by mem_limit */
+} djs_heap_udata;
+
+/* Create a heap with djs_heap_udata attached. Allocations exceeding
+ * mem_limit fail when the limit is enforced, and a mem_limit of 0 means
+ * no limit. Fatal errors are reported by goFatalHandler().
+ */
+extern duk_context *djs_create_heap(size_t mem_limit);
+
+/* Destroy a heap created by djs_create_heap() and free its udata. */
+extern void djs_destroy_heap(duk_context *ctx);
+
+/* Get the udata of the heap which ctx belongs to. */
+extern djs_heap_udata *djs_get_heap_udata(duk_context *ctx);
+
+/* Enforce mem_limit or not, the previous state is returned. The limit must
+ * only be enforced in protected calls, as a failed allocation throws an error.
+ */
+extern int djs_set_mem_limited(duk_context *ctx, int limited);
+
+/* Return and clear the flag set when an allocation is refused by mem_limit. */
+extern int djs_take_mem_limit_hit(duk_context *ctx);
+
+/* Return the id of the calling native thread. */
+extern uintptr_t djs_thread_id(void);
+
+/* Create/free the execution state of a context. */
+extern djs_exec_state *djs_new_exec_state(void);
+extern void djs_free_exec_state(djs_exec_state *state);
+
+/* Set the state of the context entered, which is checked by the execution
+ * timeout check. The previous one is returned.
+ */
+extern djs_exec_state *djs_set_current(duk_context *ctx, djs_exec_state *state);
+
+/* Set/clear the interrupted flag of a context. It can be called by any thread. */
+extern void djs_set_interrupted(djs_exec_state *state, int interrupted);
+
+#if defined(__cplusplus)
+}
+#endif  /* end 'extern "C"' wrapper */
+
+#endif  /* DJS_HEAP_H_INCLUDED */
@@ -0,0 +1,40 @@
+/*
+ *  Wrappers of the Go functions used by the module loading framework.
+ */
+
+#include "duktape.h"
+#include "djs_module.h"
+
+/* implemented in Go */
+extern duk_ret_t modSearch(duk_context *ctx);
+extern duk_ret_t modResolve(duk_context *ctx);
+
+duk_ret_t djs_mod_search(duk_context *ctx) {
+	duk_ret_t rc = modSearch(ctx);
+	if (rc == DJS_RET_THROW) {
+		return duk_throw(ctx);
+	}
+	return rc;
+}
+
+duk_ret_t djs_mod_resolve(duk_context *ctx) {
+	duk_ret_t rc = modResolve(ctx);
+	if (rc == DJS_RET_THROW) {
+		return duk_throw(ctx);
+	}
+	return rc;
+}
+
+void djs_push_error(duk_context *ctx, const char *msg, duk_size_t len) {
+	duk_push_error_object(ctx, DUK_ERR_ERROR, "%.*s", (int) len, msg);
+}
+
+static duk_ret_t djs__json_decode(duk_context *ctx, void *udata) {
+	(void) udata;
+	duk_json_decode(ctx, -1);
+	return 1;
+}
+
+duk_int_t djs_pjson_decode(duk_context *ctx) {
+	return duk_safe_call(ctx, djs__json_decode, NULL, 1, 1);
+}
@@ -0,0 +1,31 @@
+#if !defined(DJS_MODULE_H_INCLUDED)
+#define DJS_MODULE_H_INCLUDED
+
+#include "duktape.h"
+
+#if defined(__cplusplus)
+extern "C" {
+#endif
+
+/* Returned by the Go module functions to throw the value on the stack top. */
+#define DJS_RET_THROW  (-100)
+
+/* Duktape.modSearch() and Duktape.modResolve() calling the Go functions, which
+ * can't throw errors by themselves as longjmp() must not cross Go frames.
+ */
+extern duk_ret_t djs_mod_search(duk_context *ctx);
+extern duk_ret_t djs_mod_resolve(duk_context *ctx);
+
+/* Push an Error with the message msg. */
+extern void djs_push_error(duk_context *ctx, const char *msg, duk_size_t len);
+
+/* JSON.parse() the string on the stack top in protected mode, the result or
+ * the error replaces the string.
+ */
+extern duk_int_t djs_pjson_decode(duk_context *ctx);
+
+#if defined(__cplusplus)
+}
+#endif  /* end 'extern "C"' wrapper */
+
+#endif  /* DJS_MODULE_H_INCLUDED */
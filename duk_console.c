@@ -11,7 +11,7 @@
 #include "duktape.h"
 #include "duk_console.h"
 
-/* XXX: Add some form of log level filtering. */
+/* Log level filtering is done by the output writer. */
 
 /* XXX: Should all output be written via e.g. console.write(formattedMsg)?
  * This would make it easier for user code to redirect all console output
@@ -20,7 +20,38 @@
 
 /* XXX: Init console object using duk_def_prop() when that call is available. */
 
-static duk_ret_t duk__console_log_helper(duk_context *ctx, const char *error_name) {
+/* Output writer, output is written to stdout/stderr if it is NULL. */
+static duk_console_writer duk__console_writer = NULL;
+
+void duk_console_set_writer(duk_console_writer writer) {
+	duk__console_writer = writer;
+}
+
+/* Indent every line of the message at the stack top by the current group level. */
+static void duk__console_indent(duk_context *ctx) {
+	/* [ ... msg ] */
+	duk_get_global_string(ctx, "console");
+	duk_get_prop_string(ctx, -1, DUK_HIDDEN_SYMBOL("indent"));  /* [ ... msg console indent ] */
+	if (!duk_is_string(ctx, -1) || duk_get_length(ctx, -1) == 0) {
+		duk_pop_2(ctx);
+		return;
+	}
+	duk_remove(ctx, -2);  /* [ ... msg indent ] */
+
+	duk_push_string(ctx, "split");
+	duk_push_string(ctx, "\n");
+	duk_call_prop(ctx, -4, 1);  /* [ ... msg indent lines ] */
+	duk_push_string(ctx, "join");
+	duk_push_string(ctx, "\n");
+	duk_dup(ctx, -4);
+	duk_concat(ctx, 2);
+	duk_call_prop(ctx, -3, 1);  /* [ ... msg indent lines joined ] */
+	duk_remove(ctx, -2);  /* [ ... msg indent joined ] */
+	duk_concat(ctx, 2);  /* [ ... msg indented ] */
+	duk_remove(ctx, -2);  /* [ ... indented ] */
+}
+
+static duk_ret_t duk__console_log_helper(duk_context *ctx, const char *error_name, duk_int_t level) {
 	duk_uint_t flags = (duk_uint_t) duk_get_current_magic(ctx);
 	FILE *output = (flags & DUK_CONSOLE_STDOUT_ONLY) ? stdout : stderr;
 	duk_idx_t n = duk_get_top(ctx);
@@ -53,6 +84,15 @@ static duk_ret_t duk__console_log_helper(duk_context *ctx, const char *error_nam
 		duk_get_prop_string(ctx, -1, "stack");
 	}
 
+	duk__console_indent(ctx);
+
+	if (duk__console_writer != NULL) {
+		duk_size_t len;
+		const char *msg = duk_to_lstring(ctx, -1, &len);
+		duk__console_writer(ctx, level, msg, len);
+		return 0;
+	}
+
 	fprintf(output, "%s\n", duk_to_string(ctx, -1));
 	if (flags & DUK_CONSOLE_FLUSH) {
 		fflush(output);
@@ -66,32 +106,68 @@ static duk_ret_t duk__console_assert(duk_context *ctx) {
 	}
 	duk_remove(ctx, 0);
 
-	return duk__console_log_helper(ctx, "AssertionError");
+	return duk__console_log_helper(ctx, "AssertionError", DUK_CONSOLE_LEVEL_ERROR);
 }
 
 static duk_ret_t duk__console_log(duk_context *ctx) {
-	return duk__console_log_helper(ctx, NULL);
+	return duk__console_log_helper(ctx, NULL, DUK_CONSOLE_LEVEL_LOG);
+}
+
+static duk_ret_t duk__console_debug(duk_context *ctx) {
+	return duk__console_log_helper(ctx, NULL, DUK_CONSOLE_LEVEL_DEBUG);
 }
 
 static duk_ret_t duk__console_trace(duk_context *ctx) {
-	return duk__console_log_helper(ctx, "Trace");
+	return duk__console_log_helper(ctx, "Trace", DUK_CONSOLE_LEVEL_DEBUG);
 }
 
 static duk_ret_t duk__console_info(duk_context *ctx) {
-	return duk__console_log_helper(ctx, NULL);
+	return duk__console_log_helper(ctx, NULL, DUK_CONSOLE_LEVEL_INFO);
 }
 
 static duk_ret_t duk__console_warn(duk_context *ctx) {
-	return duk__console_log_helper(ctx, NULL);
+	return duk__console_log_helper(ctx, NULL, DUK_CONSOLE_LEVEL_WARN);
 }
 
 static duk_ret_t duk__console_error(duk_context *ctx) {
-	return duk__console_log_helper(ctx, "Error");
+	return duk__console_log_helper(ctx, "Error", DUK_CONSOLE_LEVEL_ERROR);
 }
 
 static duk_ret_t duk__console_dir(duk_context *ctx) {
 	/* For now, just share the formatting of .log() */
-	return duk__console_log_helper(ctx, 0);
+	return duk__console_log_helper(ctx, 0, DUK_CONSOLE_LEVEL_LOG);
+}
+
+static duk_ret_t duk__console_group(duk_context *ctx) {
+	if (duk_get_top(ctx) > 0) {
+		duk__console_log_helper(ctx, NULL, DUK_CONSOLE_LEVEL_LOG);
+	}
+
+	duk_get_global_string(ctx, "console");
+	if (!duk_get_prop_string(ctx, -1, DUK_HIDDEN_SYMBOL("indent"))) {
+		duk_pop(ctx);
+		duk_push_string(ctx, "");
+	}
+	duk_push_string(ctx, "  ");
+	duk_concat(ctx, 2);
+	duk_put_prop_string(ctx, -2, DUK_HIDDEN_SYMBOL("indent"));  /* console[indent] += "  " */
+	return 0;
+}
+
+static duk_ret_t duk__console_group_end(duk_context *ctx) {
+	duk_size_t len;
+
+	duk_get_global_string(ctx, "console");
+	if (!duk_get_prop_string(ctx, -1, DUK_HIDDEN_SYMBOL("indent")) || !duk_is_string(ctx, -1)) {
+		return 0;
+	}
+	len = duk_get_length(ctx, -1);
+	if (len < 2) {
+		return 0;
+	}
+	duk_substring(ctx, -1, 0, len - 2);
+	duk_put_prop_string(ctx, -2, DUK_HIDDEN_SYMBOL("indent"));  /* console[indent] = console[indent][2:] */
+	return 0;
 }
 
 static void duk__console_reg_vararg_func(duk_context *ctx, duk_c_function func, const char *name, duk_uint_t flags) {
@@ -140,7 +216,7 @@ void duk_console_init(duk_context *ctx, duk_uint_t flags) {
 	}
 	duk__console_reg_vararg_func(ctx, duk__console_assert, "assert", flags);
 	duk__console_reg_vararg_func(ctx, duk__console_log, "log", flags);
-	duk__console_reg_vararg_func(ctx, duk__console_log, "debug", flags);  /* alias to console.log */
+	duk__console_reg_vararg_func(ctx, duk__console_debug, "debug", flags);
 	duk__console_reg_vararg_func(ctx, duk__console_trace, "trace", flags);
 	duk__console_reg_vararg_func(ctx, duk__console_info, "info", flags);
 
@@ -154,8 +230,81 @@ void duk_console_init(duk_context *ctx, duk_uint_t flags) {
 	duk__console_reg_vararg_func(ctx, duk__console_error, "exception", flags);  /* alias to console.error */
 	duk__console_reg_vararg_func(ctx, duk__console_dir, "dir", flags);
 
+	flags = flags_orig;
+	if (!(flags & DUK_CONSOLE_STDOUT_ONLY) && !(flags & DUK_CONSOLE_STDERR_ONLY)) {
+	    flags |= DUK_CONSOLE_STDOUT_ONLY;
+	}
+	duk__console_reg_vararg_func(ctx, duk__console_group, "group", flags);
+	duk__console_reg_vararg_func(ctx, duk__console_group, "groupCollapsed", flags);  /* alias to console.group */
+	duk__console_reg_vararg_func(ctx, duk__console_group_end, "groupEnd", flags);
+
 	duk_put_global_string(ctx, "console");
 
+	/* Methods with state kept in closures, writing through the methods above. */
+	duk_eval_string_noresult(ctx,
+		"(function (C) {"
+		    "var timers=Object.create(null),counts=Object.create(null);"
+		    "function L(l){return l===undefined?'default':String(l);}"
+		    "C.time=function(l){"
+		        "l=L(l);"
+		        "if(l in timers){C.warn(\"Timer '\"+l+\"' already exists\");return;}"
+		        "timers[l]=Date.now();"
+		    "};"
+		    "C.timeLog=function(l){"
+		        "l=L(l);"
+		        "if(!(l in timers)){C.warn(\"Timer '\"+l+\"' does not exist\");return;}"
+		        "var a=Array.prototype.slice.call(arguments,1);"
+		        "a.unshift(l+': '+(Date.now()-timers[l])+'ms');"
+		        "C.log.apply(C,a);"
+		    "};"
+		    "C.timeEnd=function(l){"
+		        "l=L(l);"
+		        "if(!(l in timers)){C.warn(\"Timer '\"+l+\"' does not exist\");return;}"
+		        "var t=Date.now()-timers[l];"
+		        "delete timers[l];"
+		        "C.log(l+': '+t+'ms');"
+		    "};"
+		    "C.count=function(l){"
+		        "l=L(l);"
+		        "counts[l]=(counts[l]||0)+1;"
+		        "C.log(l+': '+counts[l]);"
+		    "};"
+		    "C.countReset=function(l){"
+		        "l=L(l);"
+		        "if(!(l in counts)){C.warn(\"Count for '\"+l+\"' does not exist\");return;}"
+		        "counts[l]=0;"
+		    "};"
+		    "C.table=function(d,cols){"
+		        "if(d===null||typeof d!=='object'){C.log(d);return;}"
+		        "function O(v){return v!==null&&typeof v==='object';}"
+		        "function S(v){return v===undefined?'':typeof v==='string'?v:O(v)?C.format(v):String(v);}"
+		        "function P(s,n){while(s.length<n){s+=' ';}return s;}"
+		        "var keys=Object.keys(d),hs=[],hasV=false,rows=[];"
+		        "if(Array.isArray(cols)){hs=cols.map(String);}"
+		        "keys.forEach(function(k){"
+		            "var v=d[k];"
+		            "if(!O(v)){hasV=true;return;}"
+		            "if(!Array.isArray(cols)){Object.keys(v).forEach(function(h){if(hs.indexOf(h)<0){hs.push(h);}});}"
+		        "});"
+		        "var head=['(index)'].concat(hs);"
+		        "if(hasV){head.push('Values');}"
+		        "keys.forEach(function(k){"
+		            "var v=d[k],r=[k];"
+		            "hs.forEach(function(h){r.push(O(v)&&h in v?S(v[h]):'');});"
+		            "if(hasV){r.push(O(v)?'':S(v));}"
+		            "rows.push(r);"
+		        "});"
+		        "var w=head.map(function(h,i){"
+		            "var m=h.length;"
+		            "rows.forEach(function(r){if(r[i].length>m){m=r[i].length;}});"
+		            "return m;"
+		        "});"
+		        "function R(r){return '| '+r.map(function(c,i){return P(c,w[i]);}).join(' | ')+' |';}"
+		        "var sep='|'+w.map(function(n){return P('',n+2).replace(/ /g,'-');}).join('|')+'|';"
+		        "C.log([R(head),sep].concat(rows.map(R)).join('\\n'));"
+		    "};"
+		"})(console)");
+
 	/* Proxy wrapping: ensures any undefined console method calls are
 	 * ignored silently.  This was required specifically by the
 	 * DeveloperToolsWG proposal (and was implemented also by Firefox:
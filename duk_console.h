@@ -19,9 +19,22 @@ extern "C" {
 /* Send output to stderr only (default is mixed stdout/stderr). */
 #define DUK_CONSOLE_STDERR_ONLY    (1U << 3)
 
+/* Levels passed to the output writer. */
+#define DUK_CONSOLE_LEVEL_DEBUG    0  /* console.debug(), console.trace() */
+#define DUK_CONSOLE_LEVEL_LOG      1  /* console.log(), console.dir() */
+#define DUK_CONSOLE_LEVEL_INFO     2  /* console.info() */
+#define DUK_CONSOLE_LEVEL_WARN     3  /* console.warn() */
+#define DUK_CONSOLE_LEVEL_ERROR    4  /* console.error(), console.exception(), console.assert() */
+
+/* Output writer, msg is the formatted message without newline. */
+typedef void (*duk_console_writer)(duk_context *ctx, duk_int_t level, const char *msg, duk_size_t len);
+
 /* Initialize the console system */
 extern void duk_console_init(duk_context *ctx, duk_uint_t flags);
 
+/* Set the output writer of all heaps, NULL to write to stdout/stderr. */
+extern void duk_console_set_writer(duk_console_writer writer);
+
 #if defined(__cplusplus)
 }
 #endif  /* end 'extern "C"' wrapper */
@@ -2,6 +2,7 @@
  *  Duktape 1.x compatible module loading framework
  */
 
+#include <string.h>
 #include "duktape.h"
 #include "duk_module_duktape.h"
 
@@ -196,6 +197,35 @@ static void duk__resolve_module_id(duk_context *ctx, const char *req_id, const c
 #define DUK__IDX_EXPORTS        9   /* default exports table */
 #define DUK__IDX_MODULE         10  /* module object containing module.exports, etc */
 
+/* Resolve the requested id with Duktape.modResolve(requested_id, require.id)
+ * if it is a function, so that the resolved id (used as the key of modLoaded)
+ * can be e.g. the path of the module file.  Pushes the resolved id and its
+ * last component like duk__resolve_module_id(), returns 0 with the stack
+ * untouched if there's no modResolve().
+ */
+static duk_bool_t duk__resolve_module_id_hook(duk_context *ctx) {
+	const char *resolved;
+	const char *last;
+
+	duk_push_global_stash(ctx);
+	duk_get_prop_string(ctx, -1, "\xff" "module:Duktape");
+	duk_remove(ctx, -2);
+	duk_get_prop_string(ctx, -1, "modResolve");
+	if (!duk_is_function(ctx, -1)) {
+		duk_pop_2(ctx);
+		return 0;
+	}
+	duk_remove(ctx, -2);  /* [ requested_id require require.id modResolve ] */
+	duk_dup(ctx, DUK__IDX_REQUESTED_ID);
+	duk_dup(ctx, DUK__IDX_REQUIRE_ID);
+	duk_call(ctx, 2);  /* [ requested_id require require.id resolved_id ] */
+
+	resolved = duk_require_string(ctx, DUK__IDX_RESOLVED_ID);
+	last = strrchr(resolved, '/');
+	duk_push_string(ctx, last != NULL ? last + 1 : resolved);
+	return 1;
+}
+
 static duk_ret_t duk__require(duk_context *ctx) {
 	const char *str_req_id;  /* requested identifier */
 	const char *str_mod_id;  /* require.id of current module */
@@ -215,7 +245,9 @@ static duk_ret_t duk__require(duk_context *ctx) {
 	duk_push_current_function(ctx);
 	duk_get_prop_string(ctx, -1, "id");
 	str_mod_id = duk_get_string(ctx, DUK__IDX_REQUIRE_ID);  /* ignore non-strings */
-	duk__resolve_module_id(ctx, str_req_id, str_mod_id);
+	if (!duk__resolve_module_id_hook(ctx)) {
+		duk__resolve_module_id(ctx, str_req_id, str_mod_id);
+	}
 	str_req_id = NULL;
 	str_mod_id = NULL;
 
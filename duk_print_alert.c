@@ -10,6 +10,13 @@
 #define DUK_PRINT_ALERT_FLUSH   /* Flush after stdout/stderr write (Duktape 1.x: yes) */
 #undef DUK_PRINT_ALERT_SMALL    /* Prefer smaller footprint (but slower and more memory churn) */
 
+/* Output writer, output is written to stdout/stderr if it is NULL. */
+static duk_print_alert_writer duk__print_alert_writer = NULL;
+
+void duk_print_alert_set_writer(duk_print_alert_writer writer) {
+	duk__print_alert_writer = writer;
+}
+
 #if defined(DUK_PRINT_ALERT_SMALL)
 static duk_ret_t duk__print_alert_helper(duk_context *ctx, FILE *fh) {
 	duk_idx_t nargs;
@@ -25,11 +32,22 @@ static duk_ret_t duk__print_alert_helper(duk_context *ctx, FILE *fh) {
 
 	if (nargs == 1 && duk_is_buffer_data(ctx, 0)) {
 		buf = (const duk_uint8_t *) duk_get_buffer_data(ctx, 0, &sz_buf);
+		if (duk__print_alert_writer != NULL) {
+			duk__print_alert_writer(ctx, fh == stderr, (const char *) buf, sz_buf);
+			return 0;
+		}
 		fwrite((const void *) buf, 1, (size_t) sz_buf, fh);
 	} else {
 		duk_push_string(ctx, " ");
 		duk_insert(ctx, 0);
 		duk_concat(ctx, nargs);
+		if (duk__print_alert_writer != NULL) {
+			duk_push_string(ctx, "\n");
+			duk_concat(ctx, 2);
+			buf = (const duk_uint8_t *) duk_get_lstring(ctx, -1, &sz_buf);
+			duk__print_alert_writer(ctx, fh == stderr, (const char *) buf, sz_buf);
+			return 0;
+		}
 		fprintf(fh, "%s\n", duk_require_string(ctx, -1));
 	}
 
@@ -94,6 +112,10 @@ static duk_ret_t duk__print_alert_helper(duk_context *ctx, FILE *fh) {
 	 */
 
 	if (sz_buf > 0) {
+		if (duk__print_alert_writer != NULL) {
+			duk__print_alert_writer(ctx, fh == stderr, (const char *) buf, sz_buf);
+			return 0;
+		}
 		fwrite((const void *) buf, 1, (size_t) sz_buf, fh);
 #if defined(DUK_PRINT_ALERT_FLUSH)
 		fflush(fh);
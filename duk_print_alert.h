@@ -11,6 +11,14 @@ extern "C" {
 
 extern void duk_print_alert_init(duk_context *ctx, duk_uint_t flags);
 
+/* Output writer, is_alert is non-zero for alert(). buf contains the trailing
+ * newline if there is one.
+ */
+typedef void (*duk_print_alert_writer)(duk_context *ctx, duk_int_t is_alert, const char *buf, duk_size_t len);
+
+/* Set the output writer of all heaps, NULL to write to stdout/stderr. */
+extern void duk_print_alert_set_writer(duk_print_alert_writer writer);
+
 #if defined(__cplusplus)
 }
 #endif  /* end 'extern "C"' wrapper */
@@ -0,0 +1,83 @@
+package djs
+
+// #include "djs_heap.h"
+import "C"
+import (
+	"context"
+	"sync"
+	"fmt"
+)
+
+// TimeoutError is returned when a running script is aborted because the
+// context.Context was cancelled or its deadline passed.
+type TimeoutError struct {
+	Err error // context.Canceled or context.DeadlineExceeded
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("execution timeout: %v", e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// execContext applies the default timeout of the context to goCtx.
+func (ctx *JsContext) execContext(goCtx context.Context) (context.Context, context.CancelFunc) {
+	if goCtx == nil {
+		goCtx = context.Background()
+	}
+	if ctx.execTimeout > 0 {
+		return context.WithTimeout(goCtx, ctx.execTimeout)
+	}
+	return goCtx, func() {}
+}
+
+// watchInterrupt interrupts the running script of the context when goCtx is done, other
+// contexts in the same heap are not affected, as the flag is checked in the state of the
+// context entered. The returned stop must be called after the script finished, it converts
+// the error caused by the interrupt to *TimeoutError. ctx.mu must be held.
+func (ctx *JsContext) watchInterrupt(goCtx context.Context) (stop func(error) error, err error) {
+	if e := goCtx.Err(); e != nil {
+		err = &TimeoutError{Err: e}
+		return
+	}
+
+	done := goCtx.Done()
+	if done == nil {
+		stop = func(err error) error {
+			return err
+		}
+		return
+	}
+
+	exec := ctx.exec
+	var wg sync.WaitGroup
+	var interrupted bool
+	finished := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-done:
+			interrupted = true
+			C.djs_set_interrupted(exec, 1)
+		case <-finished:
+		}
+	}()
+
+	stop = func(err error) error {
+		close(finished)
+		wg.Wait()
+		if !interrupted {
+			return err
+		}
+		C.djs_set_interrupted(exec, 0)
+		if err == nil {
+			return nil
+		}
+		return &TimeoutError{Err: goCtx.Err()}
+	}
+	return
+}
@@ -0,0 +1,123 @@
+package djs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const busyLoop = "var t = Date.now(); while (Date.now() - t < 2000) {}"
+
+func isDeadlineExceeded(err error) bool {
+	var timeout *TimeoutError
+	return errors.As(err, &timeout) && errors.Is(err, context.DeadlineExceeded)
+}
+
+func TestEvalTimeout(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	goCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = ctx.EvalContext(goCtx, busyLoop, nil); !isDeadlineExceeded(err) {
+		t.Fatalf("EvalContext() returned %v, want *TimeoutError", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("script aborted after %v", d)
+	}
+
+	// the context is still usable
+	if r, err := ctx.Eval("1 + 2", nil); err != nil || r != float64(3) {
+		t.Fatalf("Eval() after timeout = %v, %v", r, err)
+	}
+}
+
+func TestCallFuncTimeout(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+	if _, err = ctx.Eval("function spin() { "+busyLoop+" }", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	goCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = ctx.CallFuncContext(goCtx, "spin")
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("CallFuncContext() returned %v, want *TimeoutError", err)
+	}
+}
+
+func TestBoundFuncExecTimeout(t *testing.T) {
+	ctx, err := NewContext(WithExecTimeout(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+	if _, err = ctx.Eval("function spin(n) { if (n) { "+busyLoop+" } return n; }", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var spin func(int) (int, error)
+	if err = ctx.BindFunc("spin", &spin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = spin(1); !isDeadlineExceeded(err) {
+		t.Fatalf("spin(1) returned %v, want *TimeoutError", err)
+	}
+	if r, err := spin(0); err != nil || r != 0 {
+		t.Fatalf("spin(0) after timeout = %d, %v", r, err)
+	}
+}
+
+func TestTimeoutInSharedHeap(t *testing.T) {
+	for _, ownerFirst := range []bool{false, true} {
+		owner, err := NewContext(WithIsolatedGlobalEnv())
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := NewContext(WithIsolatedGlobalEnv())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the script of owner calls wait() which releases the heap, then the
+		// deadline of owner passes while the script of other is running.
+		entered := make(chan struct{})
+		finished := make(chan error, 1)
+		wait := func() {
+			close(entered)
+			time.Sleep(100 * time.Millisecond)
+		}
+		goCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		go func() {
+			_, err := owner.EvalContext(goCtx, "wait(); "+busyLoop, map[string]interface{}{"wait": wait})
+			finished <- err
+		}()
+		<-entered
+
+		if ownerFirst {
+			// other is entered after the deadline of owner passed.
+			time.Sleep(100 * time.Millisecond)
+		}
+		start := time.Now()
+		if _, err = other.Eval("var t = Date.now(); while (Date.now() - t < 300) {}", nil); err != nil {
+			t.Fatalf("script of other context aborted after %v: %v", time.Since(start), err)
+		}
+		if err = <-finished; !isDeadlineExceeded(err) {
+			t.Fatalf("EvalContext() of owner returned %v, want *TimeoutError", err)
+		}
+		cancel()
+
+		owner.Close()
+		other.Close()
+	}
+}
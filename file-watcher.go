@@ -0,0 +1,121 @@
+package djs
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// fileWatcher calls onChange with the path of a watched file when it is changed.
+type fileWatcher interface {
+	watch(path string) error
+	unwatch(path string)
+	close()
+}
+
+// newFileWatcher returns a watcher with inotify on Linux, or a watcher polling
+// the files every pollInterval if inotify is not available.
+func newFileWatcher(onChange func(path string), pollInterval time.Duration) fileWatcher {
+	if w, err := newNotifyWatcher(onChange); err == nil {
+		return w
+	}
+	return newPollWatcher(onChange, pollInterval)
+}
+
+type fileState struct {
+	modTime time.Time
+	size int64
+	err error
+}
+
+func statFile(path string) (st fileState) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		st.err = err
+		return
+	}
+	st.modTime, st.size = fi.ModTime(), fi.Size()
+	return
+}
+
+func (st fileState) equal(o fileState) bool {
+	if st.err != nil || o.err != nil {
+		return (st.err == nil) == (o.err == nil)
+	}
+	return st.modTime.Equal(o.modTime) && st.size == o.size
+}
+
+// pollWatcher checks the modification times and sizes of the watched files periodically.
+type pollWatcher struct {
+	onChange func(string)
+	mu sync.Mutex
+	files map[string]fileState
+	done chan struct{}
+	closeOnce sync.Once
+}
+
+func newPollWatcher(onChange func(path string), pollInterval time.Duration) *pollWatcher {
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	w := &pollWatcher{
+		onChange: onChange,
+		files: make(map[string]fileState),
+		done: make(chan struct{}),
+	}
+	go w.poll(pollInterval)
+	return w
+}
+
+func (w *pollWatcher) watch(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.files[path]; !ok {
+		w.files[path] = statFile(path)
+	}
+	return nil
+}
+
+func (w *pollWatcher) unwatch(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.files, path)
+}
+
+func (w *pollWatcher) close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *pollWatcher) poll(pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			paths := make([]string, 0, len(w.files))
+			for path := range w.files {
+				paths = append(paths, path)
+			}
+			w.mu.Unlock()
+
+			for _, path := range paths {
+				st := statFile(path)
+				w.mu.Lock()
+				old, ok := w.files[path]
+				if ok {
+					w.files[path] = st
+				}
+				w.mu.Unlock()
+				if ok && !old.equal(st) {
+					w.onChange(path)
+				}
+			}
+		}
+	}
+}
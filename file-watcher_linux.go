@@ -0,0 +1,142 @@
+package djs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const notifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// notifyWatcher watches the directories of the watched files with inotify, so that
+// files replaced by renaming, as many editors do, are also noticed.
+type notifyWatcher struct {
+	onChange func(string)
+	fd int
+	f *os.File
+	mu sync.Mutex
+	dirs map[int32]string // watch descriptor -> directory
+	wds map[string]int32 // directory -> watch descriptor
+	files map[string]map[string]bool // directory -> names of the watched files
+}
+
+func newNotifyWatcher(onChange func(path string)) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &notifyWatcher{
+		onChange: onChange,
+		fd: fd,
+		f: os.NewFile(uintptr(fd), "inotify"), // non-blocking, so Read() is interrupted by Close()
+		dirs: make(map[int32]string),
+		wds: make(map[string]int32),
+		files: make(map[string]map[string]bool),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *notifyWatcher) watch(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.wds[dir]; !ok {
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, notifyMask)
+		if err != nil {
+			return err
+		}
+		w.wds[dir] = int32(wd)
+		w.dirs[int32(wd)] = dir
+	}
+	if _, ok := w.files[dir]; !ok {
+		w.files[dir] = make(map[string]bool)
+	}
+	w.files[dir][name] = true
+	return nil
+}
+
+func (w *notifyWatcher) unwatch(path string) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names, ok := w.files[dir]
+	if !ok {
+		return
+	}
+	delete(names, name)
+	if len(names) > 0 {
+		return
+	}
+	// no file of the directory is watched any more
+	delete(w.files, dir)
+	if wd, ok := w.wds[dir]; ok {
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.wds, dir)
+		delete(w.dirs, wd)
+	}
+}
+
+func (w *notifyWatcher) close() {
+	w.f.Close()
+}
+
+func (w *notifyWatcher) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+
+		var changed []string
+		w.mu.Lock()
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			dir, ok := w.dirs[ev.Wd]
+			if !ok {
+				continue
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				// the directory was removed
+				delete(w.dirs, ev.Wd)
+				delete(w.wds, dir)
+			}
+			if len(name) == 0 {
+				// event of the directory itself
+				for name := range w.files[dir] {
+					changed = append(changed, filepath.Join(dir, name))
+				}
+				continue
+			}
+			if w.files[dir][name] {
+				changed = append(changed, filepath.Join(dir, name))
+			}
+		}
+		w.mu.Unlock()
+
+		for _, path := range changed {
+			w.onChange(path)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package djs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNotifyWatcherUnwatch(t *testing.T) {
+	w, err := newNotifyWatcher(func(string) {})
+	if err != nil {
+		t.Skip("inotify not available:", err)
+	}
+	defer w.close()
+	nw := w.(*notifyWatcher)
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.js"), filepath.Join(dir, "b.js")
+	writeScript(t, a, "")
+	writeScript(t, b, "")
+	for _, path := range []string{a, b} {
+		if err = w.watch(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() (int, int, int) {
+		nw.mu.Lock()
+		defer nw.mu.Unlock()
+		return len(nw.dirs), len(nw.wds), len(nw.files)
+	}
+
+	w.unwatch(a)
+	if dirs, wds, files := count(); dirs != 1 || wds != 1 || files != 1 {
+		t.Fatalf("%d, %d, %d directories watched after unwatching one of two files, want 1", dirs, wds, files)
+	}
+	w.unwatch(b)
+	if dirs, wds, files := count(); dirs != 0 || wds != 0 || files != 0 {
+		t.Fatalf("%d, %d, %d directories watched after unwatching all files, want 0", dirs, wds, files)
+	}
+
+	// watched again with a new watch descriptor
+	if err = w.watch(a); err != nil {
+		t.Fatal(err)
+	}
+	if dirs, wds, files := count(); dirs != 1 || wds != 1 || files != 1 {
+		t.Fatalf("%d, %d, %d directories watched after watching again, want 1", dirs, wds, files)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package djs
+
+import (
+	"fmt"
+)
+
+func newNotifyWatcher(onChange func(path string)) (fileWatcher, error) {
+	return nil, fmt.Errorf("file notification is not supported")
+}
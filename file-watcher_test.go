@@ -0,0 +1,63 @@
+package djs
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testWatcher(t *testing.T, newWatcher func(onChange func(string)) fileWatcher) {
+	changed := make(chan string, 16)
+	w := newWatcher(func(path string) { changed <- path })
+	defer w.close()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.js"), filepath.Join(dir, "b.js")
+	writeScript(t, a, "1")
+	writeScript(t, b, "1")
+	for _, path := range []string{a, b} {
+		if err := w.watch(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeScript(t, a, "22")
+	select {
+	case path := <-changed:
+		if path != a {
+			t.Fatalf("%s changed, want %s", path, a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not noticed")
+	}
+
+	w.unwatch(a)
+	w.unwatch(b)
+	for len(changed) > 0 {
+		<-changed
+	}
+	writeScript(t, a, "333")
+	select {
+	case path := <-changed:
+		t.Fatalf("%s changed after unwatched", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	testWatcher(t, func(onChange func(string)) fileWatcher {
+		return newPollWatcher(onChange, 10*time.Millisecond)
+	})
+}
+
+func TestNotifyWatcher(t *testing.T) {
+	if w, err := newNotifyWatcher(func(string) {}); err != nil {
+		t.Skip("inotify not available:", err)
+	} else {
+		w.close()
+	}
+	testWatcher(t, func(onChange func(string)) fileWatcher {
+		w, _ := newNotifyWatcher(onChange)
+		return w
+	})
+}
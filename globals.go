@@ -0,0 +1,171 @@
+package djs
+
+/*
+#include "duktape.h"
+static duk_ret_t defProp(duk_context *ctx, void *udata) {
+	// [ global key value ]
+	duk_def_prop(ctx, -3, *(duk_uint_t *) udata);
+	return 0;
+}
+static duk_int_t pDefProp(duk_context *ctx, duk_uint_t flags) {
+	return duk_safe_call(ctx, defProp, (void *) &flags, 3, 1);
+}
+static duk_ret_t delProp(duk_context *ctx, void *udata) {
+	// [ global key ]
+	(void) udata;
+	duk_dup(ctx, -1);
+	duk_def_prop(ctx, -3, DUK_DEFPROP_SET_CONFIGURABLE | DUK_DEFPROP_FORCE);
+	duk_del_prop(ctx, -2);
+	return 0;
+}
+static duk_int_t pDelProp(duk_context *ctx) {
+	return duk_safe_call(ctx, delProp, NULL, 2, 1);
+}
+*/
+import "C"
+import (
+	"fmt"
+)
+
+type globalOptions struct {
+	readOnly bool
+	nonEnumerable bool
+}
+
+type GlobalOption func(*globalOptions)
+
+// GlobalReadOnly makes the global var not writable and not deletable by scripts.
+func GlobalReadOnly() GlobalOption {
+	return func(options *globalOptions) {
+		options.readOnly = true
+	}
+}
+
+// GlobalNonEnumerable hides the global var from enumeration, e.g. `for (k in this)` and GlobalNames().
+func GlobalNonEnumerable() GlobalOption {
+	return func(options *globalOptions) {
+		options.nonEnumerable = true
+	}
+}
+
+func getGlobalOptions(options ...GlobalOption) *globalOptions {
+	var option globalOptions
+	for _, o := range options {
+		o(&option)
+	}
+	return &option
+}
+
+func (o *globalOptions) defPropFlags() C.duk_uint_t {
+	flags := C.duk_uint_t(C.DUK_DEFPROP_HAVE_VALUE | C.DUK_DEFPROP_HAVE_WEC | C.DUK_DEFPROP_FORCE)
+	if !o.readOnly {
+		flags |= C.DUK_DEFPROP_WRITABLE | C.DUK_DEFPROP_CONFIGURABLE
+	}
+	if !o.nonEnumerable {
+		flags |= C.DUK_DEFPROP_ENUMERABLE
+	}
+	return flags
+}
+
+// SetGlobal sets a global var of the context, Go values are wrapped as that of env in Eval.
+func (ctx *JsContext) SetGlobal(name string, value interface{}, options ...GlobalOption) (err error) {
+	return ctx.SetGlobals(map[string]interface{}{name: value}, options...)
+}
+
+// SetGlobals sets global vars of the context with the same options.
+func (ctx *JsContext) SetGlobals(vars map[string]interface{}, options ...GlobalOption) (err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	c := ctx.c
+	flags := getGlobalOptions(options...).defPropFlags()
+
+	for k, v := range vars {
+		C.duk_push_global_object(c) // [ global ]
+		pushString(c, k) // [ global k ]
+		pushJsProxyValue(c, v) // [ global k v ]
+		if C.pDefProp(c, flags) != 0 { // [ error ]
+			err = fmt.Errorf("failed to set global %s: %w", k, getJsError(c))
+			C.duk_pop(c) // [ ]
+			return
+		}
+		C.duk_pop(c) // [ ]
+	}
+	return
+}
+
+// DeleteGlobal deletes a global var of the context, including the read-only ones.
+func (ctx *JsContext) DeleteGlobal(name string) (err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	c := ctx.c
+
+	C.duk_push_global_object(c) // [ global ]
+	pushString(c, name) // [ global name ]
+	C.duk_dup(c, -1) // [ global name name ]
+	if !hasOwnProp(c, -3) { // [ global name ]
+		C.duk_pop_n(c, 2) // [ ]
+		return
+	}
+	if C.pDelProp(c) != 0 { // [ error ]
+		err = fmt.Errorf("failed to delete global %s: %w", name, getJsError(c))
+	}
+	C.duk_pop(c) // [ ]
+	return
+}
+
+// HasGlobal checks whether the global var exists. The properties inherited by the
+// global object, e.g. toString, are not global vars.
+func (ctx *JsContext) HasGlobal(name string) bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.checkOpen() != nil {
+		return false
+	}
+	c := ctx.c
+
+	C.duk_push_global_object(c) // [ global ]
+	defer C.duk_pop(c) // [ ]
+	pushString(c, name) // [ global name ]
+	return hasOwnProp(c, -2) // [ global ]
+}
+
+// hasOwnProp checks whether the object at objIdx has the own property of the key
+// at the stack top, the key is popped.
+func hasOwnProp(ctx *C.duk_context, objIdx C.duk_idx_t) bool {
+	objIdx = C.duk_normalize_index(ctx, objIdx)
+	// [ ... key ]
+	C.duk_get_prop_desc(ctx, objIdx, 0) // [ ... desc/undefined ]
+	defer C.duk_pop(ctx) // [ ... ]
+	return C.duk_is_object(ctx, -1) != 0
+}
+
+// GlobalNames returns the names of the enumerable global vars. Most built-in objects
+// are not enumerable, so they are not included.
+func (ctx *JsContext) GlobalNames() (names []string, err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	c := ctx.c
+
+	C.duk_push_global_object(c) // [ global ]
+	C.duk_enum(c, -1, C.DUK_ENUM_OWN_PROPERTIES_ONLY) // [ global enum ]
+	for C.duk_next(c, -1, 0) != 0 {
+		// [ global enum key ]
+		names = append(names, C.GoString(C.duk_get_string(c, -1)))
+		C.duk_pop(c) // [ global enum ]
+	}
+	C.duk_pop_n(c, 2) // [ ]
+	return
+}
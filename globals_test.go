@@ -0,0 +1,63 @@
+package djs
+
+import (
+	"testing"
+)
+
+func TestEnvOfReadOnlyGlobal(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	if err = ctx.SetGlobal("api", 1, GlobalReadOnly()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.Eval("api", map[string]interface{}{"api": 2}); err == nil {
+		t.Fatal("Eval() with env of a read-only global succeeded")
+	}
+	if res, err := ctx.Eval("api", nil); err != nil || res != float64(1) {
+		t.Fatalf("api = %v, %v", res, err)
+	}
+}
+
+func TestGlobalOwnProperties(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	if ctx.HasGlobal("toString") {
+		t.Fatal("HasGlobal(\"toString\") is true")
+	}
+	if err = ctx.DeleteGlobal("toString"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ctx.Eval("typeof toString", nil); err != nil || res != "function" {
+		t.Fatalf("typeof toString = %v, %v", res, err)
+	}
+
+	if err = ctx.SetGlobal("a", 1, GlobalReadOnly(), GlobalNonEnumerable()); err != nil {
+		t.Fatal(err)
+	}
+	if !ctx.HasGlobal("a") {
+		t.Fatal("HasGlobal(\"a\") is false")
+	}
+	names, err := ctx.GlobalNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if name == "a" {
+			t.Fatal("non-enumerable global in GlobalNames()")
+		}
+	}
+	if err = ctx.DeleteGlobal("a"); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.HasGlobal("a") {
+		t.Fatal("read-only global not deleted")
+	}
+}
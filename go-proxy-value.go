@@ -472,6 +472,7 @@ func go_interface_get(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 
 //export go_obj_get
 func go_obj_get(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
 	/* 'this' binding: handler
 	 * [0]: target
 	 * [1]: key
@@ -503,6 +504,7 @@ func go_obj_get(ctx *C.duk_context) C.duk_ret_t {
 
 //export go_obj_set
 func go_obj_set(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
 	/* 'this' binding: handler
 	 * [0]: target
 	 * [1]: key
@@ -533,6 +535,7 @@ func go_obj_set(ctx *C.duk_context) C.duk_ret_t {
 
 //export go_obj_has
 func go_obj_has(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
 	// 'this' binding: handler
 	// [0]: target
 	// [1]: key
@@ -565,6 +568,7 @@ func goDummyFunc(ctx *C.duk_context) C.duk_ret_t {
 
 //export go_func_apply
 func go_func_apply(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
 	// 'this' binding: handler
 	// [0]: target
 	// [1]: receiver
@@ -584,17 +588,23 @@ func go_func_apply(ctx *C.duk_context) C.duk_ret_t {
 
 	// make args for Golang function
 	argc := int(C.duk_get_length(ctx, 2))
-	helper := elutils.NewGolangFuncHelperDirectly(fnVal, fnType)
-	getArgs := func(i int) interface{} {
+	args := make([]interface{}, argc)
+	for i := range args {
 		C.duk_get_prop_index(ctx, 2, C.duk_uarridx_t(i)) // [ ... i-th arg ]
-		defer C.duk_pop(ctx) // [ ... ]
-
 		if goVal, err := fromJsValue(ctx); err == nil {
-			return goVal
+			args[i] = goVal
 		}
-		return nil
+		C.duk_pop(ctx) // [ ... ]
 	}
-	v, e := helper.CallGolangFunc(argc, "djs-func", getArgs) // call Golang function
+	helper := elutils.NewGolangFuncHelperDirectly(fnVal, fnType)
+	getArgs := func(i int) interface{} {
+		return args[i]
+	}
+	var v interface{}
+	var e error
+	withHeapReleased(ctx, func() {
+		v, e = helper.CallGolangFunc(argc, "djs-func", getArgs) // call Golang function
+	})
 
 	// convert result (in var v) of Golang function to that of JS.
 	// 1. error
@@ -614,6 +624,7 @@ func go_func_apply(ctx *C.duk_context) C.duk_ret_t {
 
 //export freeTarget
 func freeTarget(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
 	// Object being finalized is at stack index 0
 	if idx, isProxy := getTargetIdx(ctx); isProxy {
 		// fmt.Printf("--- freeTarget is called\n")
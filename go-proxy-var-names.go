@@ -6,6 +6,8 @@ var (
 
 	idxName = "\xFFidx\x00"
 	target = "\xFFtgt\x00"
+	scriptsName = "\xFFscripts\x00"
+	lineNumberName = "lineNumber\x00"
 	get = "get\x00"
 	set = "set\x00"
 	has = "has\x00"
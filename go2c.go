@@ -33,20 +33,14 @@ func getArgsPtr(args []uint64, val **unsafe.Pointer) {
 	*val = (*unsafe.Pointer)(unsafe.Pointer(p.Data))
 }
 
+// toBytes and toString copy the memory of Duktape, which may be freed or reused once
+// the value is popped, e.g. by another call when the heap is released.
 func toBytes(chunk *C.char, length int) []byte {
-	var b []byte
-	bs := (*reflect.SliceHeader)(unsafe.Pointer(&b))
-	bs.Data = uintptr(unsafe.Pointer(chunk))
-	bs.Len = int(length)
-	bs.Cap = int(length)
-	return b
+	return C.GoBytes(unsafe.Pointer(chunk), C.int(length))
 }
 
 func toString(chuck *C.char, length int) *string {
-	var s string
-	v := (*reflect.StringHeader)(unsafe.Pointer(&s))
-	v.Data = uintptr(unsafe.Pointer(chuck))
-	v.Len = int(length)
+	s := C.GoStringN(chuck, C.int(length))
 	return &s
 }
 
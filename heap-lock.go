@@ -0,0 +1,165 @@
+package djs
+
+// #include "duktape.h"
+// #include "djs_heap.h"
+import "C"
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+var (
+	ErrHeapBusy = errors.New("heap is busy running a script")
+	ErrHeapFatal = errors.New("heap is unusable after a fatal error")
+)
+
+// heapLock serializes the execution of all contexts in a heap, for a Duktape heap
+// must not be entered by two native threads at the same time. When Javascript calls
+// a Go function, the heap is suspended and the lock is released by releaseHeap(), so
+// that the Go function can call the contexts in the same heap, and so can other goroutines.
+// A context whose script is suspended can only be entered by the Go function called by
+// the script, other goroutines wait by enter() until the Go function returns, otherwise
+// the frames of the Duktape thread would be interleaved.
+type heapLock struct {
+	mu sync.Mutex
+	running map[uintptr][]C.uintptr_t // thread context -> native threads calling Go functions from its script
+	resumed *sync.Cond // broadcast when no script of a thread context is suspended
+	fatal bool // set by a fatal error of Duktape, the heap must not be entered any more
+}
+
+func (l *heapLock) Lock() {
+	l.mu.Lock()
+}
+
+func (l *heapLock) Unlock() {
+	l.mu.Unlock()
+}
+
+// busy tells whether a script of the heap is running, i.e. it's suspended when calling
+// a Go function. It must be called with the lock held.
+func (l *heapLock) busy() bool {
+	return len(l.running) > 0
+}
+
+// runningIn tells whether a script is running in the thread ctx. It must be called with
+// the lock held.
+func (l *heapLock) runningIn(ctx uintptr) bool {
+	return len(l.running[ctx]) > 0
+}
+
+// enter waits until the thread ctx can be entered by the caller, i.e. no script is running
+// in it, or the caller is the Go function called by the running script, which runs in the
+// same native thread during the cgo callback. It must be called with the lock held.
+func (l *heapLock) enter(ctx uintptr) {
+	for l.runningIn(ctx) && !l.calledBy(ctx, C.djs_thread_id()) {
+		l.resumed.Wait()
+	}
+}
+
+func (l *heapLock) calledBy(ctx uintptr, tid C.uintptr_t) bool {
+	for _, t := range l.running[ctx] {
+		if t == tid {
+			return true
+		}
+	}
+	return false
+}
+
+// withHeapReleased calls fn with the heap released by releaseHeap(), fn must not touch the heap.
+func withHeapReleased(ctx *C.duk_context, fn func()) {
+	defer releaseHeap(ctx)()
+	fn()
+}
+
+var (
+	heapLocks = make(map[uintptr]*heapLock) // heap udata -> lock of the heap
+	heapLocksMu = &sync.RWMutex{}
+)
+
+func heapKey(ctx *C.duk_context) uintptr {
+	return uintptr(unsafe.Pointer(C.djs_get_heap_udata(ctx)))
+}
+
+// newHeapLock creates the lock of the heap which ctx belongs to.
+func newHeapLock(ctx *C.duk_context) *heapLock {
+	l := &heapLock{running: make(map[uintptr][]C.uintptr_t)}
+	l.resumed = sync.NewCond(&l.mu)
+	heapLocksMu.Lock()
+	defer heapLocksMu.Unlock()
+	heapLocks[heapKey(ctx)] = l
+	return l
+}
+
+// delHeapLock must be called before the heap is destroyed.
+func delHeapLock(ctx *C.duk_context) {
+	heapLocksMu.Lock()
+	defer heapLocksMu.Unlock()
+	delete(heapLocks, heapKey(ctx))
+}
+
+// releaseHeap is called by the Go functions called by Javascript before running Go code
+// which may use the contexts in the same heap, e.g. a Go function of the user. The heap
+// is suspended and its lock is released until reacquire is called, which must be called
+// before touching the heap again.
+func releaseHeap(ctx *C.duk_context) (reacquire func()) {
+	heapLocksMu.RLock()
+	l, ok := heapLocks[heapKey(ctx)]
+	heapLocksMu.RUnlock()
+	if !ok {
+		return func() {}
+	}
+
+	thread := uintptr(unsafe.Pointer(ctx))
+	l.running[thread] = append(l.running[thread], C.djs_thread_id())
+	state := &C.duk_thread_state{}
+	C.duk_suspend(ctx, state)
+	current := C.djs_set_current(ctx, nil) // other contexts may be entered before resumed
+	l.Unlock()
+
+	return func() {
+		l.Lock()
+		if !l.fatal {
+			C.djs_set_current(ctx, current)
+			C.duk_resume(ctx, state)
+		}
+		if n := len(l.running[thread]) - 1; n > 0 {
+			l.running[thread] = l.running[thread][:n]
+		} else {
+			delete(l.running, thread)
+			l.resumed.Broadcast()
+		}
+	}
+}
+
+// ctxRef is registered for every open context, so that the Go code holding a duk_context
+// instead of a *JsContext, e.g. a Go func bound to a Javascript function, can lock the
+// heap and check whether the context is still open.
+type ctxRef struct {
+	mu *heapLock
+	exec *C.djs_exec_state
+}
+
+var (
+	ctxRefs = make(map[uintptr]*ctxRef) // duk context -> ref
+	ctxRefsMu = &sync.RWMutex{}
+)
+
+func setCtxRef(ctx uintptr, mu *heapLock, exec *C.djs_exec_state) {
+	ctxRefsMu.Lock()
+	defer ctxRefsMu.Unlock()
+	ctxRefs[ctx] = &ctxRef{mu: mu, exec: exec}
+}
+
+func delCtxRef(ctx uintptr) {
+	ctxRefsMu.Lock()
+	defer ctxRefsMu.Unlock()
+	delete(ctxRefs, ctx)
+}
+
+// getCtxRef returns nil if the context is closed.
+func getCtxRef(ctx uintptr) *ctxRef {
+	ctxRefsMu.RLock()
+	defer ctxRefsMu.RUnlock()
+	return ctxRefs[ctx]
+}
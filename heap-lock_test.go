@@ -0,0 +1,210 @@
+package djs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGlobalHeapConcurrentEval(t *testing.T) {
+	const n = 8
+	var contexts []*JsContext
+	for i := 0; i < n; i++ {
+		ctx, err := NewContext(WithIsolatedGlobalEnv())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ctx.Close()
+		contexts = append(contexts, ctx)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i, ctx := range contexts {
+		wg.Add(1)
+		go func(i int, ctx *JsContext) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				res, err := ctx.Eval("var s = 0; for (var k = 0; k < 100; k++) s += k; s + i", map[string]interface{}{"i": i})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if res != float64(4950+i) {
+					errs <- fmt.Errorf("context %d: got %v", i, res)
+					return
+				}
+			}
+		}(i, ctx)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestGoFunctionCallingSameHeap(t *testing.T) {
+	ctx1, err := NewContext(WithGlobalHeap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx1.Close()
+	ctx2, err := NewContext(WithIsolatedGlobalEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx2.Close()
+
+	// a Go function called by ctx1 calls ctx2 in the same heap, and another goroutine
+	// uses the heap while ctx1 is suspended
+	callOther := func(a int) (interface{}, error) {
+		done := make(chan error)
+		go func() {
+			_, err := ctx2.Eval("1", nil)
+			done <- err
+		}()
+		if err := <-done; err != nil {
+			return nil, err
+		}
+		return ctx2.Eval("a * 2", map[string]interface{}{"a": a})
+	}
+	res, err := ctx1.Eval("callOther(21)", map[string]interface{}{"callOther": callOther})
+	if err != nil || res != float64(42) {
+		t.Fatalf("Eval() = %v, %v", res, err)
+	}
+}
+
+func TestHeapBusy(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithGlobalHeap()}} {
+		ctx, err := NewContext(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var closeErr, destroyErr error
+		env := map[string]interface{}{
+			"closeSelf": func() {
+				closeErr = ctx.Close()
+				destroyErr = DestroyJsHeap()
+			},
+		}
+		if _, err = ctx.Eval("closeSelf()", env); err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(closeErr, ErrHeapBusy) {
+			t.Fatalf("Close() in a Go function returned %v, want ErrHeapBusy", closeErr)
+		}
+		if len(options) > 0 && !errors.Is(destroyErr, ErrHeapBusy) {
+			t.Fatalf("DestroyJsHeap() in a Go function returned %v, want ErrHeapBusy", destroyErr)
+		}
+		if res, err := ctx.Eval("1 + 1", nil); err != nil || res != float64(2) {
+			t.Fatalf("Eval() after ErrHeapBusy = %v, %v", res, err)
+		}
+		if err = ctx.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ctx.Eval("1", nil); !errors.Is(err, ErrContextClosed) {
+			t.Fatalf("Eval() after Close() returned %v, want ErrContextClosed", err)
+		}
+	}
+}
+
+func BenchmarkGetGlobal(b *testing.B) {
+	ctx, err := NewContext()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ctx.Close()
+	if err = ctx.SetGlobal("a", 1); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = ctx.GetGlobal("a"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestContextCalledConcurrently(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithIsolatedGlobalEnv()}} {
+		ctx, err := NewContext(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A is suspended in a Go function when B calls the context. B must wait until A returns,
+		// otherwise B would be suspended in its Go function with its frames on top of A's when A
+		// resumes.
+		aEntered, bEntered, aDone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+		var aOnce, bOnce sync.Once
+		env := map[string]interface{}{
+			"slow": func(name string) string {
+				switch name {
+				case "a":
+					aOnce.Do(func() { close(aEntered) })
+					select {
+					case <-bEntered:
+					case <-time.After(100 * time.Millisecond):
+					}
+				case "b":
+					bOnce.Do(func() { close(bEntered) })
+					select {
+					case <-aDone:
+					case <-time.After(time.Second):
+					}
+				}
+				return name
+			},
+		}
+		if err = ctx.SetGlobals(env); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		results := make([]interface{}, 2)
+		errs := make([]error, 2)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			defer close(aDone)
+			results[0], errs[0] = ctx.Eval("var a = []; for (var i = 0; i < 3; i++) a.push(slow('a')); a.join('')", nil)
+		}()
+		<-aEntered
+		go func() {
+			defer wg.Done()
+			results[1], errs[1] = ctx.Eval("var b = []; for (var j = 0; j < 3; j++) b.push(slow('b')); b.join('')", nil)
+		}()
+		wg.Wait()
+
+		if errs[0] != nil || errs[1] != nil || results[0] != "aaa" || results[1] != "bbb" {
+			t.Fatalf("Eval() = %v, %v and %v, %v", results[0], errs[0], results[1], errs[1])
+		}
+		ctx.Close()
+	}
+}
+
+func TestGoFunctionCallingSameContext(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	var inner interface{}
+	env := map[string]interface{}{
+		"callSelf": func() (interface{}, error) {
+			if err := ctx.SetGlobal("x", 20); err != nil {
+				return nil, err
+			}
+			return ctx.Eval("x + 1", nil)
+		},
+	}
+	if inner, err = ctx.Eval("callSelf() * 2", env); err != nil || inner != float64(42) {
+		t.Fatalf("Eval() = %v, %v", inner, err)
+	}
+}
@@ -2,64 +2,47 @@ package djs
 
 import (
 	"sync"
-	"os"
-	"time"
 )
 
-type jsCtx struct {
-	jsvm *JsContext
-	mt   time.Time
-}
-
 var (
-	jsCtxCache map[string]*jsCtx
-	lock *sync.Mutex
+	defaultScriptCache *ScriptCache
+	defaultScriptCacheOnce sync.Once
 )
 
+func getDefaultScriptCache() *ScriptCache {
+	defaultScriptCacheOnce.Do(func() {
+		defaultScriptCache = NewScriptCache(ScriptCacheOptions{})
+	})
+	return defaultScriptCache
+}
+
+// InitCache creates the cache used by LoadFileFromCache(). It is not necessary any more,
+// the cache is created when it is used the first time.
 func InitCache() {
-	if lock != nil {
-		return
-	}
-	lock = &sync.Mutex{}
-	jsCtxCache = make(map[string]*jsCtx)
+	getDefaultScriptCache()
 }
 
+// LoadFileFromCache returns the context evaluated the script file path, the context is
+// recreated if the script file or any module loaded by it is changed. Calls with different
+// options get different contexts, and vars are ignored if the context is cached. It uses a
+// ScriptCache without limits. The returned context is never closed by the cache, even after
+// it's replaced, it's only freed when not reachable, so a Duktape heap is held by every context
+// still referenced. AcquireFileFromCache() is recommended, whose contexts are closed when they
+// are replaced and released.
 func LoadFileFromCache(path string, vars map[string]interface{}, options ...Option) (ctx *JsContext, existing bool, err error) {
-	lock.Lock()
-	defer lock.Unlock()
-
-	jsC, ok := jsCtxCache[path]
+	return getDefaultScriptCache().Load(path, vars, options...)
+}
 
-	if !ok {
-		if ctx, err = createJSContext(path, vars, options...); err != nil {
-			return
-		}
-		fi, _ := os.Stat(path)
-		jsC = &jsCtx{
-			jsvm: ctx,
-			mt: fi.ModTime(),
-		}
-		jsCtxCache[path] = jsC
-		return
-	}
+// AcquireFileFromCache is the same as LoadFileFromCache, but the context is referenced by the
+// returned ScriptRef, and it keeps working until released even if the script is changed.
+func AcquireFileFromCache(path string, vars map[string]interface{}, options ...Option) (*ScriptRef, error) {
+	return getDefaultScriptCache().Acquire(path, vars, options...)
+}
 
-	fi, e := os.Stat(path)
-	if e != nil {
-		err = e
-		return
-	}
-	mt := fi.ModTime()
-	if !jsC.mt.Equal(mt) {
-		if ctx, err = createJSContext(path, vars, options...); err != nil {
-			return
-		}
-		jsC.jsvm = ctx
-		jsC.mt = mt
-	} else {
-		existing = true
-		ctx = jsC.jsvm
-	}
-	return
+// GetCacheInfo returns the diagnostic information of the script path cached by LoadFileFromCache(),
+// one for the contexts created with different options.
+func GetCacheInfo(path string) []*CacheInfo {
+	return getDefaultScriptCache().Info(path)
 }
 
 func createJSContext(path string, vars map[string]interface{}, options ...Option) (ctx *JsContext, err error) {
@@ -1,8 +1,24 @@
 package djs
 
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
 type Options struct {
 	withGlobalHeap bool
+	newGlobalEnv bool
 	moduleHome string
+	moduleLoader ModuleLoader
+	modulePaths []string
+	moduleReload ModuleReloadMode
+	execTimeout time.Duration
+	memoryLimit uint64
+	consoleWriter io.Writer
+	printWriter io.Writer
+	logger *slog.Logger
+	consoleLevel ConsoleLevel
 }
 
 type Option func(*Options)
@@ -19,12 +35,100 @@ func WithGlobalHeap() Option {
 	}
 }
 
+// WithIsolatedGlobalEnv is the same as WithGlobalHeap(), but the context has its own
+// global object and built-ins instead of sharing them with other contexts, while the
+// memory of the global heap is still shared.
+func WithIsolatedGlobalEnv() Option {
+	return func(options *Options) {
+		options.withGlobalHeap = true
+		options.newGlobalEnv = true
+	}
+}
+
 func WithModuleHome(moduleHome string) Option {
 	return func(options *Options) {
 		options.moduleHome = moduleHome
 	}
 }
 
+// WithModulePaths adds the directories where modules are searched after the module home,
+// like NODE_PATH of Node.js. It is ignored if WithModuleLoader() is used.
+func WithModulePaths(paths ...string) Option {
+	return func(options *Options) {
+		options.modulePaths = append(options.modulePaths, paths...)
+	}
+}
+
+// WithModuleLoader loads the modules required by Javascript with loader instead of
+// reading them from the module home. Contexts sharing the global object of the global
+// heap also share the cache of loaded modules.
+func WithModuleLoader(loader ModuleLoader) Option {
+	return func(options *Options) {
+		options.moduleLoader = loader
+	}
+}
+
+// WithModuleReload makes require() reload the modules changed since loaded, by checking
+// their modification times or hashes. Only the changed modules are reloaded, the modules
+// which have required them keep the old exports until they are reloaded too.
+func WithModuleReload(mode ModuleReloadMode) Option {
+	return func(options *Options) {
+		options.moduleReload = mode
+	}
+}
+
+// WithExecTimeout sets the wall-clock timeout of every Eval/EvalFile/CallFunc
+// and bound func call. The script will be aborted with a *TimeoutError if it
+// runs longer than timeout.
+func WithExecTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.execTimeout = timeout
+	}
+}
+
+// WithMemoryLimit limits the bytes can be allocated by the heap of the context. A script
+// allocating more memory will fail with a *MemoryLimitError, and so does NewContext() if the
+// limit is too small for the built-ins. It is ignored by the contexts created with WithGlobalHeap(),
+// which share one heap.
+func WithMemoryLimit(limit uint64) Option {
+	return func(options *Options) {
+		options.memoryLimit = limit
+	}
+}
+
+// WithConsoleWriter writes the output of console.xxx() to w, one line for every call.
+func WithConsoleWriter(w io.Writer) Option {
+	return func(options *Options) {
+		options.consoleWriter = w
+	}
+}
+
+// WithPrintWriter writes the output of print() and alert() to w.
+func WithPrintWriter(w io.Writer) Option {
+	return func(options *Options) {
+		options.printWriter = w
+	}
+}
+
+// WithLogger logs the output of console.xxx(), print() and alert() with logger,
+// unless they are written to the writers set by WithConsoleWriter() or WithPrintWriter().
+// console.debug()/trace() are logged at Debug level, console.warn() and alert() at Warn
+// level, console.error()/exception()/assert() at Error level, and others at Info level.
+func WithLogger(logger *slog.Logger) Option {
+	return func(options *Options) {
+		options.logger = logger
+	}
+}
+
+// WithConsoleLevel discards the output of console methods with levels lower than level,
+// e.g. WithConsoleLevel(LevelWarn) only keeps console.warn() and console.error(). It also
+// applies to the output captured by CaptureOutput() and EvalCapture().
+func WithConsoleLevel(level ConsoleLevel) Option {
+	return func(options *Options) {
+		options.consoleLevel = level
+	}
+}
+
 func getOptions(options ...Option) *Options {
 	var option Options
 	for _, o := range options {
@@ -0,0 +1,97 @@
+package djs
+
+// #include "duktape.h"
+// #include "djs_module.h"
+// const char *getCString(duk_context *ctx, duk_idx_t idx);
+// extern duk_ret_t freeTarget(duk_context *ctx);
+// static duk_bool_t isError(duk_context *ctx, duk_idx_t idx) {
+//	return duk_is_error(ctx, idx);
+// }
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// JsError is the error thrown by Javascript.
+type JsError struct {
+	Name       string      // name of the Error, e.g. "TypeError"
+	Message    string
+	FileName   string
+	LineNumber int
+	Stack      string
+	Value      interface{} // the thrown value if it is not an Error
+	Err        error       // the Go error thrown to Javascript, e.g. *ModuleNotFoundError
+	desc       string
+}
+
+func (e *JsError) Error() string {
+	return e.desc
+}
+
+func (e *JsError) Unwrap() error {
+	return e.Err
+}
+
+// pushGoError pushes an Error with the message of err, err is attached to the
+// Error and can be got by JsError.Err when the Error is caught by Go.
+func pushGoError(ctx *C.duk_context, err error) {
+	msg := err.Error()
+	var cMsg *C.char
+	var msgLen C.int
+	getStrPtrLen(&msg, &cMsg, &msgLen)
+	C.djs_push_error(ctx, cMsg, C.duk_size_t(msgLen)) // [ ... error ]
+
+	var name *C.char
+	ptr := getPtrStore(uintptr(unsafe.Pointer(ctx)))
+	var v interface{} = err
+	idx := ptr.register(&v)
+	C.duk_push_uint(ctx, C.duk_uint_t(idx)) // [ ... error idx ]
+	getStrPtr(&idxName, &name)
+	C.duk_put_prop_string(ctx, -2, name) // [ ... error ] with error[name] = idx
+
+	C.duk_push_c_function(ctx, (*[0]byte)(C.freeTarget), 1) // [ ... error finalizer ]
+	C.duk_set_finalizer(ctx, -2) // [ ... error ] with finalizer = freeTarget
+}
+
+// getJsError converts the error at the stack top to *JsError, the error is left on the stack.
+func getJsError(ctx *C.duk_context) error {
+	// [ ... error ]
+	e := &JsError{}
+	if C.isError(ctx, -1) != 0 {
+		e.Name = getStringProp(ctx, "name")
+		e.Message = getStringProp(ctx, "message")
+		e.FileName = getStringProp(ctx, "fileName")
+		e.Stack = getStringProp(ctx, "stack")
+		var lineNumber *C.char
+		getStrPtr(&lineNumberName, &lineNumber)
+		C.duk_get_prop_string(ctx, -1, lineNumber) // [ ... error lineNumber ]
+		e.LineNumber = int(C.duk_get_int(ctx, -1))
+		C.duk_pop(ctx) // [ ... error ]
+		if v, ok := getTargetValue(ctx, -1); ok {
+			e.Err, _ = v.(error)
+		}
+	} else if C.duk_get_error_code(ctx, -1) == 0 {
+		e.Value, _ = fromJsValue(ctx)
+		if s, ok := e.Value.(string); ok {
+			e.Value = fmt.Sprintf("%s", s) // deep copy
+		}
+	}
+
+	C.duk_dup(ctx, -1) // [ ... error error ]
+	e.desc = C.GoString(C.getCString(ctx, -1))
+	C.duk_pop(ctx) // [ ... error ]
+	return e
+}
+
+func getStringProp(ctx *C.duk_context, name string) string {
+	// [ ... obj ]
+	pushString(ctx, name) // [ ... obj name ]
+	C.duk_get_prop(ctx, -2) // [ ... obj value ]
+	defer C.duk_pop(ctx) // [ ... obj ]
+
+	if C.duk_is_undefined(ctx, -1) != 0 {
+		return ""
+	}
+	return C.GoString(C.getCString(ctx, -1))
+}
@@ -1,40 +1,60 @@
 package djs
 
 // #include "duktape.h"
+// #include "djs_heap.h"
 // extern duk_ret_t freeJsFunc(duk_context *ctx);
 import "C"
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
+	"context"
 	"reflect"
 	"time"
+	"unsafe"
 )
 
-func bindFunc(ctx *JsContext, funcName string, funcVarPtr interface{}) (err error) {
+func bindFunc(ctx *JsContext, goCtx context.Context, funcName string, funcVarPtr interface{}) (err error) {
 	helper, e := elutils.NewEmbeddingFuncHelper(funcVarPtr)
 	if e != nil {
 		err = e
 		return
 	}
-	helper.BindEmbeddingFunc(wrapFunc(ctx, funcName, helper))
+	helper.BindEmbeddingFunc(wrapFunc(ctx, goCtx, funcName, helper))
 	return
 }
 
-func wrapFunc(ctx *JsContext, funcName string, helper *elutils.EmbeddingFuncHelper) elutils.FnGoFunc {
+func wrapFunc(ctx *JsContext, goCtx context.Context, funcName string, helper *elutils.EmbeddingFuncHelper) elutils.FnGoFunc {
 	return func(args []reflect.Value) (results []reflect.Value) {
 		ctx.mu.Lock()
 		defer ctx.mu.Unlock()
 
+		if err := ctx.checkOpen(); err != nil {
+			return helper.ToGolangResults(nil, false, err)
+		}
+		callCtx, cancel := ctx.execContext(goCtx)
+		defer cancel()
+		stop, err := ctx.watchInterrupt(callCtx)
+		if err != nil {
+			return helper.ToGolangResults(nil, false, err)
+		}
+
 		c := ctx.c
 		// reload the function when calling go-function
 		C.duk_push_global_object(c) // [ global ]
 		getVar(c, funcName) // [ global function ]
 
-		return callJsFuncFromGo(c, helper, args)
+		goVal, isArray, err := callJsFunc(c, helper, args)
+		return helper.ToGolangResults(goVal, isArray, stop(err))
 	}
 }
 
-// called by wrapFunc() and fromJsFunc::bindGoFunc()
+// called by fromJsFunc::bindGoFunc()
 func callJsFuncFromGo(ctx *C.duk_context, helper *elutils.EmbeddingFuncHelper, args []reflect.Value)  (results []reflect.Value) {
+	goVal, isArray, err := callJsFunc(ctx, helper, args)
+	return helper.ToGolangResults(goVal, isArray, err)
+}
+
+// called by wrapFunc() and callJsFuncFromGo()
+func callJsFunc(ctx *C.duk_context, helper *elutils.EmbeddingFuncHelper, args []reflect.Value) (goVal interface{}, isArray bool, err error) {
 	// [ some-obj function ]
 
 	// push js args
@@ -47,16 +67,23 @@ func callJsFuncFromGo(ctx *C.duk_context, helper *elutils.EmbeddingFuncHelper, a
 	// [ some-obj function arg1 arg2 ... argN ]
 
 	// call JS function
-	C.duk_call(ctx, C.int(argc)) // [ some-obj retval ]
+	defer C.duk_pop_n(ctx, 2) // [ ]
+	restore := limitMemory(ctx, true)
+	rc := C.duk_pcall(ctx, C.int(argc))
+	restore()
+	if rc != 0 { // [ some-obj error ]
+		err = memoryLimitError(ctx, getJsError(ctx))
+		return
+	}
+	// [ some-obj retval ]
 
 	// convert result to golang
-	goVal, err := fromJsValue(ctx)
-	results = helper.ToGolangResults(goVal, C.duk_is_array(ctx, -1) != 0, err)
-	C.duk_pop_n(ctx, 2) // [ ]
+	goVal, err = fromJsValue(ctx)
+	isArray = C.duk_is_array(ctx, -1) != 0
 	return
 }
 
-func callFunc(ctx *C.duk_context, args ...interface{}) {
+func callFunc(ctx *C.duk_context, args ...interface{}) (err error) {
 	// [ obj function ]
 	n := len(args)
 	for _, arg := range args {
@@ -64,11 +91,19 @@ func callFunc(ctx *C.duk_context, args ...interface{}) {
 	}
 	// [ obj function arg1 arg2 ... argN ]
 
-	C.duk_call(ctx, C.int(n)) // [ obj retval ]
+	restore := limitMemory(ctx, true)
+	rc := C.duk_pcall(ctx, C.int(n))
+	restore()
+	if rc != 0 { // [ obj error ]
+		err = memoryLimitError(ctx, getJsError(ctx))
+	}
+	// [ obj retval ]
+	return
 }
 
 //export freeJsFunc
 func freeJsFunc(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
 	// [0] function
 	// [1] ...
 	if idx, isProxy := getTargetIdx(ctx); isProxy {
@@ -107,6 +142,8 @@ func fromJsFunc(ctx *C.duk_context) (bindGoFunc elutils.FnBindGoFunc) {
 		C.duk_pop(ctx) // [ function ]
 	}
 
+	// the context may be closed when the Go func is called
+	ref := getCtxRef(uintptr(unsafe.Pointer(ctx)))
 	bindGoFunc = func(fnVarPtr interface{}) elutils.FnGoFunc {
 		helper, e := elutils.NewEmbeddingFuncHelper(fnVarPtr)
 		if e != nil {
@@ -114,6 +151,20 @@ func fromJsFunc(ctx *C.duk_context) (bindGoFunc elutils.FnBindGoFunc) {
 		}
 
 		return func(args []reflect.Value) (results []reflect.Value) {
+			if ref == nil {
+				return helper.ToGolangResults(nil, false, ErrContextClosed)
+			}
+			ref.mu.Lock()
+			defer ref.mu.Unlock()
+			ref.mu.enter(uintptr(unsafe.Pointer(ctx)))
+			if getCtxRef(uintptr(unsafe.Pointer(ctx))) != ref {
+				return helper.ToGolangResults(nil, false, ErrContextClosed)
+			}
+			if ref.mu.fatal {
+				return helper.ToGolangResults(nil, false, ErrHeapFatal)
+			}
+			C.djs_set_current(ctx, ref.exec)
+
 			// reload the function when calling go-function
 			C.duk_push_global_stash(ctx) // [ stash ]
 			C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(idx)) // [ stash function ]
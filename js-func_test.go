@@ -0,0 +1,35 @@
+package djs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBoundJsFuncAfterClose(t *testing.T) {
+	for _, withGlobalHeap := range []bool{false, true} {
+		var options []Option
+		if withGlobalHeap {
+			options = append(options, WithGlobalHeap())
+		}
+		ctx, err := NewContext(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var add func(int, int) (int, error)
+		keep := func(f func(int, int) (int, error)) {
+			add = f
+		}
+		if _, err = ctx.Eval("keep(function(a, b) { return a + b; })", map[string]interface{}{"keep": keep}); err != nil {
+			t.Fatal(err)
+		}
+		if r, err := add(1, 2); err != nil || r != 3 {
+			t.Fatalf("add(1, 2) = %d, %v", r, err)
+		}
+
+		ctx.Close()
+		if _, err = add(1, 2); !errors.Is(err, ErrContextClosed) {
+			t.Fatalf("add() after Close() returned %v, want ErrContextClosed", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package djs
+
+// #include "duktape.h"
+// #include "djs_heap.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// MemoryLimitError is returned when a script fails because the heap exceeds the limit
+// set by WithMemoryLimit().
+type MemoryLimitError struct {
+	Limit uint64
+	Err   error // the *JsError thrown by Javascript, nil if the limit was exceeded before running
+}
+
+func (e *MemoryLimitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("memory limit of %d bytes exceeded", e.Limit)
+	}
+	return fmt.Sprintf("memory limit of %d bytes exceeded: %v", e.Limit, e.Err)
+}
+
+func (e *MemoryLimitError) Unwrap() error {
+	return e.Err
+}
+
+// limitMemory enforces the memory limit of the heap or not until restore is called. As
+// an allocation exceeding the limit throws an error, the limit is only enforced in protected
+// calls, and it's lifted in the Go functions called by Javascript, for errors must not be
+// thrown across Go frames.
+func limitMemory(ctx *C.duk_context, limited bool) (restore func()) {
+	var on C.int
+	if limited {
+		on = 1
+	}
+	old := C.djs_set_mem_limited(ctx, on)
+	return func() {
+		C.djs_set_mem_limited(ctx, old)
+	}
+}
+
+// memoryLimitError converts err returned by a protected call to *MemoryLimitError if
+// it's caused by an allocation refused by the memory limit.
+func memoryLimitError(ctx *C.duk_context, err error) error {
+	if C.djs_take_mem_limit_hit(ctx) == 0 || err == nil {
+		return err
+	}
+	var jsErr *JsError
+	if !errors.As(err, &jsErr) || !strings.Contains(jsErr.Message, "alloc failed") {
+		return err
+	}
+	return &MemoryLimitError{Limit: uint64(C.djs_get_heap_udata(ctx).mem_limit), Err: err}
+}
+
+// checkMemoryLimit returns *MemoryLimitError if the heap has exceeded the memory limit,
+// e.g. by the values of env, before running a script.
+func checkMemoryLimit(ctx *C.duk_context) error {
+	udata := C.djs_get_heap_udata(ctx)
+	if udata.mem_limit == 0 || udata.mem_used <= udata.mem_limit {
+		return nil
+	}
+	C.duk_gc(ctx, 0)
+	if udata.mem_used <= udata.mem_limit {
+		return nil
+	}
+	return &MemoryLimitError{Limit: uint64(udata.mem_limit)}
+}
+
+//export goFatalHandler
+func goFatalHandler(udata unsafe.Pointer, msg *C.char) {
+	fatalError(uintptr(udata), C.GoString(msg))
+}
+
+// fatalError marks the heap with the key unusable, as its state is broken, and unwinds the
+// Duktape frames to the Go caller instead of aborting the process. Later calls of the contexts
+// in the heap return ErrHeapFatal, the global heap can be recreated by ResetJsHeap(). The lock
+// of the heap is held by the caller, which is running a script.
+func fatalError(key uintptr, msg string) {
+	heapLocksMu.RLock()
+	l, ok := heapLocks[key]
+	heapLocksMu.RUnlock()
+	if ok {
+		l.fatal = true
+	}
+	panic(fmt.Sprintf("duktape fatal error: %s", msg))
+}
@@ -0,0 +1,138 @@
+package djs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemoryLimitTooSmall(t *testing.T) {
+	var memErr *MemoryLimitError
+	if _, err := NewContext(WithMemoryLimit(32 << 10)); !errors.As(err, &memErr) {
+		t.Fatalf("NewContext() returned %v, want *MemoryLimitError", err)
+	}
+}
+
+func TestMemoryLimitEnv(t *testing.T) {
+	ctx, err := NewContext(WithMemoryLimit(1 << 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	var memErr *MemoryLimitError
+	if _, err = ctx.Eval("big.length", map[string]interface{}{"big": strings.Repeat("x", 2<<20)}); !errors.As(err, &memErr) {
+		t.Fatalf("Eval() returned %v, want *MemoryLimitError", err)
+	}
+}
+
+func TestMemoryLimitScript(t *testing.T) {
+	ctx, err := NewContext(WithMemoryLimit(1 << 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	repeat := func(n int) string {
+		return strings.Repeat("y", n)
+	}
+	scripts := []string{
+		"var a = []; for (;;) a.push('item ' + a.length);",
+		"var s = 'x'; for (;;) s = s + s;",
+		"var b = []; for (;;) b.push(repeat(1000));", // allocating in Go functions
+	}
+	for _, script := range scripts {
+		var memErr *MemoryLimitError
+		if _, err = ctx.Eval(script, map[string]interface{}{"repeat": repeat}); !errors.As(err, &memErr) {
+			t.Fatalf("%s: Eval() returned %v, want *MemoryLimitError", script, err)
+		}
+		var jsErr *JsError
+		if !errors.As(err, &jsErr) {
+			t.Fatalf("%s: no *JsError wrapped in %v", script, err)
+		}
+	}
+
+	// the context is still usable after the garbage is released
+	if res, err := ctx.Eval("a = b = s = null; 1 + 2", nil); err != nil || res != float64(3) {
+		t.Fatalf("Eval() = %v, %v", res, err)
+	}
+	if _, err = ctx.Eval("throw new Error('not memory')", nil); err == nil || errors.As(err, new(*MemoryLimitError)) {
+		t.Fatalf("Eval() returned %v, want a plain *JsError", err)
+	}
+}
+
+// fatal simulates a fatal error of Duktape when running a script of ctx.
+func fatal(t *testing.T, ctx *JsContext) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("fatal error doesn't panic")
+		}
+	}()
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	fatalError(heapKey(ctx.c), "test")
+}
+
+func TestFatalError(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var add func(int, int) (int, error)
+	keep := func(f func(int, int) (int, error)) {
+		add = f
+	}
+	if _, err = ctx.Eval("keep(function(a, b) { return a + b; })", map[string]interface{}{"keep": keep}); err != nil {
+		t.Fatal(err)
+	}
+
+	fatal(t, ctx)
+	if _, err = ctx.Eval("1", nil); !errors.Is(err, ErrHeapFatal) {
+		t.Fatalf("Eval() after a fatal error returned %v, want ErrHeapFatal", err)
+	}
+	if _, err = add(1, 2); !errors.Is(err, ErrHeapFatal) {
+		t.Fatalf("bound func after a fatal error returned %v, want ErrHeapFatal", err)
+	}
+	if err = ctx.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFatalErrorInGlobalHeap(t *testing.T) {
+	defer DestroyJsHeap()
+	ctx1, err := NewContext(WithGlobalHeap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx2, err := NewContext(WithGlobalHeap())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fatal(t, ctx1)
+	if _, err = ctx2.Eval("1", nil); !errors.Is(err, ErrHeapFatal) {
+		t.Fatalf("Eval() of another context returned %v, want ErrHeapFatal", err)
+	}
+	if _, err = NewContext(WithGlobalHeap()); !errors.Is(err, ErrHeapFatal) {
+		t.Fatalf("NewContext() returned %v, want ErrHeapFatal", err)
+	}
+	if err = ctx1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the global heap is usable after recreated
+	if err = ResetJsHeap(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx2.Eval("1", nil); !errors.Is(err, ErrContextClosed) {
+		t.Fatalf("Eval() after the heap reset returned %v, want ErrContextClosed", err)
+	}
+	ctx3, err := NewContext(WithGlobalHeap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := ctx3.Eval("1 + 2", nil); err != nil || r != float64(3) {
+		t.Fatalf("Eval() in the new heap = %v, %v", r, err)
+	}
+}
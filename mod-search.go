@@ -1,48 +1,209 @@
 package djs
 
 // #include "duktape.h"
-// extern duk_ret_t modSearch(duk_context *ctx);
+// #include "djs_module.h"
 // static const char *getCString(duk_context *ctx, duk_idx_t idx);
 import "C"
 import (
-	"strings"
+	"encoding/json"
+	"unsafe"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"sync"
 )
 
 var (
 	mod_path = "\xFFmodPath"
+	module_filename = "filename\x00"
+	module_exports = "exports\x00"
+	error_code = "code\x00"
 )
 
+//export modResolve
+func modResolve(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
+	/* Nargs was given as 2 and we get the following stack arguments:
+	 *   index 0: requested id
+	 *   index 1: id of the requiring module, undefined if not required by a module
+	 */
+	id := C.GoString(C.getCString(ctx, 0))
+	var from string
+	if C.duk_is_string(ctx, 1) != 0 {
+		from = C.GoString(C.getCString(ctx, 1))
+	} else {
+		// required by the script file, or by the functions defined in it
+		from = getEntryFile(ctx)
+	}
+
+	if _, ok := getNativeModule(ctx, id); ok {
+		pushString(ctx, id)
+		return 1
+	}
+	loader := getModuleLoader(ctx)
+	var modPath string
+	var err error
+	withHeapReleased(ctx, func() {
+		modPath, err = loader.Resolve(id, from)
+	})
+	if err != nil {
+		pushGoError(ctx, err) // [ ... err ]
+		setErrorCode(ctx, err)
+		return C.DJS_RET_THROW
+	}
+	invalidateChangedModule(ctx, modPath)
+	pushString(ctx, modPath)
+	return 1
+}
+
 //export modSearch
 func modSearch(ctx *C.duk_context) C.duk_ret_t {
+	defer limitMemory(ctx, false)()
 	/* Nargs was given as 4 and we get the following stack arguments:
-	 *   index 0: id
+	 *   index 0: id, resolved by modResolve
 	 *   index 1: require
 	 *   index 2: exports
 	 *   index 3: module
 	 */
+	var src *C.char
+	var size C.int
 	modPath := C.GoString(C.getCString(ctx, 0))
-	if !strings.HasSuffix(modPath, ".js") {
-		modPath = fmt.Sprintf("%s.js", modPath)
+	if exports, ok := getNativeModule(ctx, modPath); ok {
+		var name *C.char
+		getStrPtr(&module_exports, &name)
+		pushJsProxyValue(ctx, exports) // [ ... exports ]
+		C.duk_put_prop_string(ctx, 3, name) // [ ... ] with module.exports = exports
+		return 0
 	}
+	loader := getModuleLoader(ctx)
+	var b []byte
+	var err error
+	withHeapReleased(ctx, func() {
+		b, err = loader.Load(modPath)
+	})
+	if err != nil {
+		pushGoError(ctx, fmt.Errorf("failed to load module %s: %w", modPath, err)) // [ ... err ]
+		return C.DJS_RET_THROW
+	}
+
+	// module.filename is used as the file name in error messages and stack traces
+	var filename *C.char
+	getStrPtr(&module_filename, &filename)
+	pushString(ctx, modPath) // [ ... modPath ]
+	C.duk_put_prop_string(ctx, 3, filename) // [ ... ] with module.filename = modPath
+	setModuleStamp(ctx, modPath, b)
+	addModuleDep(ctx, modPath, b)
 
-	modHome := getModuleHome(ctx)
-	absModPath := toAbsPath(modHome, modPath)
-	b, err := os.ReadFile(absModPath)
+	var exports interface{}
+	var ok bool
+	withHeapReleased(ctx, func() {
+		exports, ok, err = moduleExports(path.Ext(modPath), b)
+	})
 	if err != nil {
+		pushGoError(ctx, fmt.Errorf("failed to load module %s: %w", modPath, err)) // [ ... err ]
+		return C.DJS_RET_THROW
+	}
+	if ok {
+		var name *C.char
+		getStrPtr(&module_exports, &name)
+		if raw, isRaw := exports.(json.RawMessage); isRaw {
+			getBytesPtrLen(raw, &src, &size)
+			C.duk_push_lstring(ctx, src, C.size_t(size)) // [ ... json ]
+			if C.djs_pjson_decode(ctx) != 0 {
+				return C.DJS_RET_THROW // [ ... err ]
+			}
+		} else {
+			pushJsProxyValue(ctx, exports) // [ ... exports ]
+		}
+		C.duk_put_prop_string(ctx, 3, name) // [ ... ] with module.exports = exports
 		return 0
 	}
 
-	var src *C.char
-	var size C.int
 	getBytesPtrLen(b, &src, &size)
 
 	C.duk_push_lstring(ctx, src, C.size_t(size))
 	return 1
 }
 
+// moduleConf is the module settings of a duk context.
+type moduleConf struct {
+	loader ModuleLoader
+	paths []string // set by WithModulePaths()
+	natives map[string]map[string]interface{} // registered by JsContext.RegisterModule()
+	reload ModuleReloadMode
+	deps []moduleDep // module files loaded, appended only
+	entry string // the script file evaluated by EvalFile() last time, guarded by moduleConfsMu
+}
+
+var (
+	moduleConfs = make(map[uintptr]*moduleConf) // duk context -> module settings
+	moduleConfsMu = &sync.RWMutex{}
+)
+
+func setModuleConf(ctx *C.duk_context, o *Options) *moduleConf {
+	moduleConfsMu.Lock()
+	defer moduleConfsMu.Unlock()
+	conf := &moduleConf{
+		loader: o.moduleLoader,
+		paths: o.modulePaths,
+		reload: o.moduleReload,
+		natives: make(map[string]map[string]interface{}),
+	}
+	moduleConfs[uintptr(unsafe.Pointer(ctx))] = conf
+	return conf
+}
+
+// setEntryFile records the script file evaluated by EvalFile(), so that require() called
+// out of modules resolves relative ids from the directory of the script file like Node.js.
+func (conf *moduleConf) setEntryFile(entry string) {
+	moduleConfsMu.Lock()
+	defer moduleConfsMu.Unlock()
+	conf.entry = entry
+}
+
+func getEntryFile(ctx *C.duk_context) string {
+	moduleConfsMu.RLock()
+	defer moduleConfsMu.RUnlock()
+	if conf, ok := moduleConfs[uintptr(unsafe.Pointer(ctx))]; ok {
+		return conf.entry
+	}
+	return ""
+}
+
+func delModuleConf(ctx uintptr) {
+	moduleConfsMu.Lock()
+	defer moduleConfsMu.Unlock()
+	delete(moduleConfs, ctx)
+}
+
+// getModuleLoader returns the loader set by WithModuleLoader(), or a loader
+// of the module home and the module paths.
+func getModuleLoader(ctx *C.duk_context) ModuleLoader {
+	moduleConfsMu.RLock()
+	conf, ok := moduleConfs[uintptr(unsafe.Pointer(ctx))]
+	moduleConfsMu.RUnlock()
+	if !ok {
+		return NewDirLoader(getModuleHome(ctx))
+	}
+	if conf.loader != nil {
+		return conf.loader
+	}
+	return NewDirLoader(append([]string{getModuleHome(ctx)}, conf.paths...)...)
+}
+
+// setErrorCode sets error.code = "MODULE_NOT_FOUND" like Node.js if err is *ModuleNotFoundError.
+func setErrorCode(ctx *C.duk_context, err error) {
+	var notFound *ModuleNotFoundError
+	if !errors.As(err, &notFound) {
+		return
+	}
+	var name *C.char
+	getStrPtr(&error_code, &name)
+	pushString(ctx, "MODULE_NOT_FOUND") // [ ... error code ]
+	C.duk_put_prop_string(ctx, -2, name) // [ ... error ] with error.code = code
+}
+
 func setObjFunction(ctx *C.duk_context, funcName string, fn C.duk_c_function, nargs int) {
 	var cFuncName *C.char
 	var funcNameLen C.int
@@ -63,7 +224,8 @@ func setModSearch(ctx *C.duk_context, moduleHome string) {
 	getStrPtrLen(&duktape, &cDuktape, &length)
 
 	C.duk_get_global_lstring(ctx, cDuktape, C.size_t(length))
-	setObjFunction(ctx, "modSearch", (C.duk_c_function)(C.modSearch), 4)
+	setObjFunction(ctx, "modSearch", (C.duk_c_function)(C.djs_mod_search), 4)
+	setObjFunction(ctx, "modResolve", (C.duk_c_function)(C.djs_mod_resolve), 2)
 	C.duk_pop(ctx)
 }
 
@@ -0,0 +1,47 @@
+package djs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireFromEntryFile(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "node_modules", "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeScript(t, filepath.Join(dir, "m.js"), "exports.name = 'm'")
+	writeScript(t, filepath.Join(dir, "node_modules", "pkg", "index.js"), "exports.name = 'pkg'")
+	writeScript(t, filepath.Join(home, "m.js"), "exports.name = 'home'")
+	main := filepath.Join(dir, "main.js")
+	writeScript(t, main, `
+		var top = require('./m').name + ',' + require('pkg').name;
+		function later() { return require('./m').name }
+	`)
+
+	ctx, err := NewContext(WithModuleHome(home))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+	if _, err = ctx.EvalFile(main, nil); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ctx.GetGlobal("top"); err != nil || res != "m,pkg" {
+		t.Fatalf("top = %v, %v", res, err)
+	}
+	if res, err := ctx.CallFunc("later"); err != nil || res != "m" {
+		t.Fatalf("later() = %v, %v", res, err)
+	}
+
+	c := NewScriptCache(ScriptCacheOptions{Options: []Option{WithModuleHome(home)}})
+	defer c.Close()
+	cached, _, err := c.Load(main, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := cached.GetGlobal("top"); err != nil || res != "m,pkg" {
+		t.Fatalf("top of the cached context = %v, %v", res, err)
+	}
+}
@@ -0,0 +1,283 @@
+package djs
+
+// #include "duktape.h"
+// const char *getCString(duk_context *ctx, duk_idx_t idx);
+import "C"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// ModuleReloadMode decides whether a module changed after loaded is reloaded by require().
+type ModuleReloadMode int
+
+const (
+	ModuleReloadNever   ModuleReloadMode = iota // modules are cached until invalidated
+	ModuleReloadModTime // reload modules whose modification times changed
+	ModuleReloadHash    // reload modules whose SHA-256 of the contents changed
+)
+
+// ModuleModTimer is implemented by the ModuleLoaders which know the modification times of
+// modules, e.g. that returned by NewDirLoader() and NewFSLoader(). ModuleReloadModTime works
+// as ModuleReloadHash with the loaders not implementing it.
+type ModuleModTimer interface {
+	ModTime(path string) (time.Time, error)
+}
+
+// ModuleInfo describes a module in the module cache.
+type ModuleInfo struct {
+	Id       string // the key of the module cache, the path returned by ModuleLoader.Resolve() or the name of a Go-native module
+	FileName string // module.filename, empty for Go-native modules
+}
+
+var errNoModTime = errors.New("no modification time")
+
+var (
+	module_duktape = "\xFFmodule:Duktape\x00"
+	module_loaded = "modLoaded\x00"
+	module_stamp = "\xFFstamp\x00"
+)
+
+// pushModLoaded pushes Duktape.modLoaded, the module cache.
+func pushModLoaded(ctx *C.duk_context) {
+	var name *C.char
+	C.duk_push_global_stash(ctx) // [ stash ]
+	getStrPtr(&module_duktape, &name)
+	C.duk_get_prop_string(ctx, -1, name) // [ stash Duktape ]
+	getStrPtr(&module_loaded, &name)
+	C.duk_get_prop_string(ctx, -1, name) // [ stash Duktape modLoaded ]
+	C.duk_remove(ctx, -2) // [ stash modLoaded ]
+	C.duk_remove(ctx, -2) // [ modLoaded ]
+}
+
+// moduleStamp returns the modification time or the hash of the module at path, content
+// is used if it's not nil.
+func moduleStamp(loader ModuleLoader, mode ModuleReloadMode, path string, content []byte) (string, error) {
+	if mode == ModuleReloadModTime {
+		if mt, ok := loader.(ModuleModTimer); ok {
+			t, err := mt.ModTime(path)
+			if err == nil {
+				return strconv.FormatInt(t.UnixNano(), 10), nil
+			}
+			if err != errNoModTime {
+				return "", err
+			}
+		}
+	}
+	if content == nil {
+		var err error
+		if content, err = loader.Load(path); err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// setModuleStamp saves the stamp of the module being loaded to module[module_stamp]. It's
+// called by modSearch, the module object is at index 3.
+func setModuleStamp(ctx *C.duk_context, modPath string, content []byte) {
+	mode := getModuleReloadMode(ctx)
+	if mode == ModuleReloadNever {
+		return
+	}
+	stamp, err := moduleStamp(getModuleLoader(ctx), mode, modPath, content)
+	if err != nil {
+		return
+	}
+	var name *C.char
+	getStrPtr(&module_stamp, &name)
+	pushString(ctx, stamp) // [ ... stamp ]
+	C.duk_put_prop_string(ctx, 3, name) // [ ... ] with module[module_stamp] = stamp
+}
+
+// invalidateChangedModule removes the module at modPath from the module cache if it's
+// changed since loaded, so that it will be reloaded by require().
+func invalidateChangedModule(ctx *C.duk_context, modPath string) {
+	mode := getModuleReloadMode(ctx)
+	if mode == ModuleReloadNever {
+		return
+	}
+
+	pushModLoaded(ctx) // [ modLoaded ]
+	defer C.duk_pop(ctx) // [ ]
+	pushString(ctx, modPath) // [ modLoaded modPath ]
+	if C.duk_get_prop(ctx, -2) == 0 { // [ modLoaded module ]
+		C.duk_pop(ctx) // [ modLoaded ]
+		return
+	}
+	var name *C.char
+	getStrPtr(&module_stamp, &name)
+	C.duk_get_prop_string(ctx, -1, name) // [ modLoaded module stamp ]
+	if C.duk_is_string(ctx, -1) == 0 {
+		// no stamp, e.g. a Go-native module
+		C.duk_pop_2(ctx) // [ modLoaded ]
+		return
+	}
+	stamp := C.GoString(C.getCString(ctx, -1))
+	C.duk_pop_2(ctx) // [ modLoaded ]
+
+	if current, err := moduleStamp(getModuleLoader(ctx), mode, modPath, nil); err == nil && current == stamp {
+		return
+	}
+	pushString(ctx, modPath) // [ modLoaded modPath ]
+	C.duk_del_prop(ctx, -2) // [ modLoaded ]
+}
+
+// moduleDep is a file loaded by a context, with its stamp when it was loaded.
+type moduleDep struct {
+	path string
+	stamp string
+	loader ModuleLoader // nil for the script file of EvalFile(), which is read from the local disk
+}
+
+func fileStamp(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(fi.ModTime().UnixNano(), 10), nil
+}
+
+func newModuleDep(path string, loader ModuleLoader, content []byte) (dep moduleDep, err error) {
+	dep = moduleDep{path: path, loader: loader}
+	if loader == nil {
+		dep.stamp, err = fileStamp(path)
+	} else {
+		dep.stamp, err = moduleStamp(loader, ModuleReloadModTime, path, content)
+	}
+	return
+}
+
+// current returns the stamp of the file now.
+func (dep *moduleDep) current() (string, error) {
+	if dep.loader == nil {
+		return fileStamp(dep.path)
+	}
+	return moduleStamp(dep.loader, ModuleReloadModTime, dep.path, nil)
+}
+
+// isFile reports whether the file is on the local disk, so that it can be watched.
+func (dep *moduleDep) isFile() bool {
+	switch l := dep.loader.(type) {
+	case nil, *dirLoader:
+		return true
+	case *chainLoader:
+		if loader, ok := l.resolved.Load(dep.path); ok {
+			_, ok = loader.(*dirLoader)
+			return ok
+		}
+	}
+	return false
+}
+
+// addModuleDep records the module loaded by modSearch.
+func addModuleDep(ctx *C.duk_context, modPath string, content []byte) {
+	dep, err := newModuleDep(modPath, getModuleLoader(ctx), content)
+	if err != nil {
+		return
+	}
+	moduleConfsMu.Lock()
+	defer moduleConfsMu.Unlock()
+	if conf, ok := moduleConfs[uintptr(unsafe.Pointer(ctx))]; ok {
+		conf.deps = append(conf.deps, dep)
+	}
+}
+
+// moduleDeps returns the module files loaded by the context.
+func (ctx *JsContext) moduleDeps() []moduleDep {
+	moduleConfsMu.RLock()
+	defer moduleConfsMu.RUnlock()
+	return ctx.modConf.deps
+}
+
+// LoadedModules returns the modules in the module cache, including those being loaded.
+func (ctx *JsContext) LoadedModules() (modules []ModuleInfo, err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	c := ctx.c
+	pushModLoaded(c) // [ modLoaded ]
+	defer C.duk_pop(c) // [ ]
+	if C.duk_is_object(c, -1) == 0 {
+		err = fmt.Errorf("no module cache")
+		return
+	}
+
+	C.duk_enum(c, -1, C.DUK_ENUM_OWN_PROPERTIES_ONLY) // [ modLoaded enum ]
+	for C.duk_next(c, -1, 1) != 0 {
+		// [ modLoaded enum id module ]
+		m := ModuleInfo{Id: C.GoString(C.getCString(c, -2))}
+		if C.duk_is_object(c, -1) != 0 {
+			m.FileName = getStringProp(c, "filename")
+		}
+		modules = append(modules, m)
+		C.duk_pop_2(c) // [ modLoaded enum ]
+	}
+	C.duk_pop(c) // [ modLoaded ]
+	return
+}
+
+// InvalidateModule removes the module with id from the module cache, so that it will
+// be loaded again by the next require(). The modules which have required it are not
+// affected.
+func (ctx *JsContext) InvalidateModule(id string) (err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	c := ctx.c
+	pushModLoaded(c) // [ modLoaded ]
+	defer C.duk_pop(c) // [ ]
+	if C.duk_is_object(c, -1) == 0 {
+		return fmt.Errorf("no module cache")
+	}
+	pushString(c, id) // [ modLoaded id ]
+	C.duk_del_prop(c, -2) // [ modLoaded ]
+	return
+}
+
+// InvalidateModules clears the module cache.
+func (ctx *JsContext) InvalidateModules() (err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	var name *C.char
+	c := ctx.c
+	C.duk_push_global_stash(c) // [ stash ]
+	defer C.duk_pop(c) // [ ]
+	getStrPtr(&module_duktape, &name)
+	C.duk_get_prop_string(c, -1, name) // [ stash Duktape ]
+	if C.duk_is_object(c, -1) == 0 {
+		C.duk_pop(c) // [ stash ]
+		return fmt.Errorf("no module cache")
+	}
+	getStrPtr(&module_loaded, &name)
+	C.duk_push_bare_object(c) // [ stash Duktape {} ]
+	C.duk_put_prop_string(c, -2, name) // [ stash Duktape ] with Duktape.modLoaded = {}
+	C.duk_pop(c) // [ stash ]
+	return
+}
+
+func getModuleReloadMode(ctx *C.duk_context) ModuleReloadMode {
+	moduleConfsMu.RLock()
+	defer moduleConfsMu.RUnlock()
+	if conf, ok := moduleConfs[uintptr(unsafe.Pointer(ctx))]; ok {
+		return conf.reload
+	}
+	return ModuleReloadNever
+}
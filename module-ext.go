@@ -0,0 +1,74 @@
+package djs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ModuleExtHandler converts the content of a module file to the exports of the module.
+// The exports is converted to a Javascript value as the values of env passed to Eval(),
+// e.g. Go functions can be called and Go maps are proxied, except that a json.RawMessage
+// is parsed by JSON.parse().
+type ModuleExtHandler func(content []byte) (exports interface{}, err error)
+
+var (
+	moduleExts = []string{".js", ".json"} // extensions tried when resolving modules
+	moduleExtHandlers = map[string]ModuleExtHandler{
+		".json": func(content []byte) (interface{}, error) {
+			return json.RawMessage(content), nil
+		},
+	}
+	moduleExtsMu = &sync.RWMutex{}
+)
+
+// RegisterModuleExt makes require() load the files with extension ext, e.g. ".txt", with
+// handler instead of evaluating them as Javascript. The extension is tried after ".js" and
+// ".json" when resolving a module id without extension. ".js" can't be registered, and the
+// handler of ".json", which parses the file with JSON.parse(), can be replaced.
+func RegisterModuleExt(ext string, handler ModuleExtHandler) error {
+	if !strings.HasPrefix(ext, ".") || len(ext) == 1 {
+		return fmt.Errorf("invalid extension %q", ext)
+	}
+	if ext == ".js" {
+		return fmt.Errorf("extension .js can't be registered")
+	}
+	if handler == nil {
+		return fmt.Errorf("handler of extension %s is nil", ext)
+	}
+
+	moduleExtsMu.Lock()
+	defer moduleExtsMu.Unlock()
+	if _, ok := moduleExtHandlers[ext]; !ok {
+		exts := make([]string, len(moduleExts), len(moduleExts)+1)
+		copy(exts, moduleExts)
+		moduleExts = append(exts, ext)
+	}
+	moduleExtHandlers[ext] = handler
+	return nil
+}
+
+func getModuleExts() []string {
+	moduleExtsMu.RLock()
+	defer moduleExtsMu.RUnlock()
+	return moduleExts
+}
+
+func getModuleExtHandler(ext string) (handler ModuleExtHandler, ok bool) {
+	moduleExtsMu.RLock()
+	defer moduleExtsMu.RUnlock()
+	handler, ok = moduleExtHandlers[ext]
+	return
+}
+
+// moduleExports returns the exports of the module file with extension ext converted by
+// its handler, or false if it is a Javascript module.
+func moduleExports(ext string, content []byte) (exports interface{}, ok bool, err error) {
+	handler, ok := getModuleExtHandler(ext)
+	if !ok {
+		return
+	}
+	exports, err = handler(content)
+	return
+}
@@ -0,0 +1,49 @@
+package djs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestModuleExtExports(t *testing.T) {
+	// like the values decoded by gopkg.in/yaml.v2
+	err := RegisterModuleExt(".testyml", func(content []byte) (interface{}, error) {
+		return map[interface{}]interface{}{
+			"name": string(content),
+			"nested": map[interface{}]interface{}{"n": 1},
+			"list": []interface{}{1, "b"},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RegisterModuleExt(".testfn", func(content []byte) (interface{}, error) {
+		return map[string]interface{}{
+			"add": func(a, b int) int { return a + b },
+		}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeScript(t, filepath.Join(dir, "conf.testyml"), "conf")
+	writeScript(t, filepath.Join(dir, "lib.testfn"), "")
+	writeScript(t, filepath.Join(dir, "data.json"), `{"a": [1, 2]}`)
+	main := filepath.Join(dir, "main.js")
+	writeScript(t, main, `
+		var conf = require('./conf.testyml');
+		var res = [conf.name, conf.nested.n, conf.list[1], require('./lib.testfn').add(1, 2), require('./data').a.length].join(',');
+	`)
+
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+	if _, err = ctx.EvalFile(main, nil); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ctx.GetGlobal("res"); err != nil || res != "conf,1,b,3,2" {
+		t.Fatalf("res = %v, %v", res, err)
+	}
+}
@@ -0,0 +1,352 @@
+package djs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModuleLoader finds and loads the modules required by Javascript.
+type ModuleLoader interface {
+	// Resolve returns the path of the module id required by the module at path from.
+	// If require() is called out of modules, from is the script file evaluated by
+	// EvalFile(), or empty if no script file is evaluated. The path is used as
+	// module.filename and the key of the module cache.
+	Resolve(id string, from string) (path string, err error)
+
+	// Load returns the source of the module at path returned by Resolve.
+	Load(path string) ([]byte, error)
+}
+
+// ModuleNotFoundError is returned by ModuleLoader.Resolve if the module can't be found. It is
+// thrown by require() as an Error with code "MODULE_NOT_FOUND", and can be got from the
+// error returned by Eval with errors.As().
+type ModuleNotFoundError struct {
+	Id    string   // the id passed to require()
+	From  string   // path of the requiring module, empty if not required by a module
+	Tried []string // paths tried
+	Err   error    // the last error when trying the paths
+}
+
+func (e *ModuleNotFoundError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cannot find module '%s'", e.Id)
+	if len(e.From) > 0 {
+		fmt.Fprintf(&b, " required by %s", e.From)
+	}
+	if len(e.Tried) > 0 {
+		fmt.Fprintf(&b, ", tried: %s", strings.Join(e.Tried, ", "))
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *ModuleNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// moduleFiles is the file system where modules are resolved.
+type moduleFiles interface {
+	// stat returns nil if p is a regular file.
+	stat(p string) error
+	readFile(p string) ([]byte, error)
+}
+
+// moduleResolution resolves a module id like Node.js, and records the paths tried.
+type moduleResolution struct {
+	files moduleFiles
+	tried []string
+	err error // the last error of stat
+}
+
+func (r *moduleResolution) isFile(p string) bool {
+	r.tried = append(r.tried, p)
+	if err := r.files.stat(p); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// loadAsFile tries p, then p with the extensions of modules.
+func (r *moduleResolution) loadAsFile(p string) (string, bool) {
+	if r.isFile(p) {
+		return p, true
+	}
+	for _, ext := range getModuleExts() {
+		if r.isFile(p + ext) {
+			return p + ext, true
+		}
+	}
+	return "", false
+}
+
+func (r *moduleResolution) loadIndex(p string) (string, bool) {
+	for _, ext := range getModuleExts() {
+		if index := path.Join(p, "index" + ext); r.isFile(index) {
+			return index, true
+		}
+	}
+	return "", false
+}
+
+// loadAsDirectory tries the "main" field of p/package.json, then p/index with the
+// extensions of modules.
+func (r *moduleResolution) loadAsDirectory(p string) (string, bool) {
+	if b, err := r.files.readFile(path.Join(p, "package.json")); err == nil {
+		var pkg struct {
+			Main string `json:"main"`
+		}
+		if err = json.Unmarshal(b, &pkg); err == nil && len(pkg.Main) > 0 {
+			main := path.Join(p, pkg.Main)
+			if f, ok := r.loadAsFile(main); ok {
+				return f, true
+			}
+			if f, ok := r.loadIndex(main); ok {
+				return f, true
+			}
+		}
+	}
+	return r.loadIndex(p)
+}
+
+func (r *moduleResolution) load(p string) (string, bool) {
+	if f, ok := r.loadAsFile(p); ok {
+		return f, true
+	}
+	return r.loadAsDirectory(p)
+}
+
+func isRelativeId(id string) bool {
+	return id == "." || id == ".." || strings.HasPrefix(id, "./") || strings.HasPrefix(id, "../")
+}
+
+// resolveModule resolves the module id required by the module at path from like Node.js:
+// relative ids are relative to the directory of from, and others are searched in the
+// node_modules directories from the directory of from up to the root, then in roots.
+// The first root is used as the directory of from if from is empty.
+func resolveModule(files moduleFiles, roots []string, id string, from string) (string, error) {
+	r := &moduleResolution{files: files}
+	base := roots[0]
+	if len(from) > 0 {
+		base = path.Dir(from)
+	}
+
+	switch {
+	case path.IsAbs(id):
+		if f, ok := r.load(path.Clean(id)); ok {
+			return f, nil
+		}
+	case isRelativeId(id):
+		if f, ok := r.load(path.Join(base, id)); ok {
+			return f, nil
+		}
+	default:
+		for dir := base; ; dir = path.Dir(dir) {
+			if path.Base(dir) != "node_modules" {
+				if f, ok := r.load(path.Join(dir, "node_modules", id)); ok {
+					return f, nil
+				}
+			}
+			if path.Dir(dir) == dir {
+				break
+			}
+		}
+		for _, root := range roots {
+			if f, ok := r.load(path.Join(root, id)); ok {
+				return f, nil
+			}
+		}
+	}
+	return "", &ModuleNotFoundError{Id: id, From: from, Tried: r.tried, Err: r.err}
+}
+
+type dirLoader struct {
+	dirs []string
+}
+
+// NewDirLoader returns a ModuleLoader loading modules from the directories dirs, relative
+// to the directory of the executable. It is the default loader with the directories set
+// by WithModuleHome() and WithModulePaths().
+func NewDirLoader(dirs ...string) ModuleLoader {
+	l := &dirLoader{}
+	for _, dir := range dirs {
+		l.dirs = append(l.dirs, toAbsPath(exePath, dir))
+	}
+	if len(l.dirs) == 0 {
+		l.dirs = []string{exePath}
+	}
+	return l
+}
+
+func (l *dirLoader) Resolve(id string, from string) (string, error) {
+	return resolveModule(l, l.dirs, id, from)
+}
+
+func (l *dirLoader) Load(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (l *dirLoader) ModTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func (l *dirLoader) stat(p string) error {
+	fi, err := os.Stat(p)
+	if err == nil && !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", p)
+	}
+	return err
+}
+
+func (l *dirLoader) readFile(p string) ([]byte, error) {
+	return os.ReadFile(p)
+}
+
+type fsLoader struct {
+	fsys fs.FS
+	dirs []string
+}
+
+// NewFSLoader returns a ModuleLoader loading modules from fsys, e.g. an embed.FS.
+// Modules are searched in the directories dirs of fsys, or the root of fsys if no dirs.
+func NewFSLoader(fsys fs.FS, dirs ...string) ModuleLoader {
+	l := &fsLoader{fsys: fsys}
+	for _, dir := range dirs {
+		l.dirs = append(l.dirs, path.Clean(dir))
+	}
+	if len(l.dirs) == 0 {
+		l.dirs = []string{"."}
+	}
+	return l
+}
+
+func (l *fsLoader) Resolve(id string, from string) (string, error) {
+	return resolveModule(l, l.dirs, id, from)
+}
+
+func (l *fsLoader) Load(path string) ([]byte, error) {
+	return fs.ReadFile(l.fsys, path)
+}
+
+func (l *fsLoader) ModTime(path string) (time.Time, error) {
+	fi, err := fs.Stat(l.fsys, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func (l *fsLoader) stat(p string) error {
+	if !fs.ValidPath(p) {
+		return &fs.PathError{Op: "stat", Path: p, Err: fs.ErrInvalid}
+	}
+	fi, err := fs.Stat(l.fsys, p)
+	if err == nil && !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", p)
+	}
+	return err
+}
+
+func (l *fsLoader) readFile(p string) ([]byte, error) {
+	if !fs.ValidPath(p) {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrInvalid}
+	}
+	return fs.ReadFile(l.fsys, p)
+}
+
+type mapLoader struct {
+	modules map[string]string
+}
+
+// NewMapLoader returns a ModuleLoader loading modules from memory. The keys of modules
+// are paths like "lib/util.js" or "node_modules/pkg/index.js", and the values are the
+// sources of the modules.
+func NewMapLoader(modules map[string]string) ModuleLoader {
+	return &mapLoader{modules: modules}
+}
+
+func (l *mapLoader) Resolve(id string, from string) (string, error) {
+	return resolveModule(l, []string{"."}, id, from)
+}
+
+func (l *mapLoader) Load(path string) ([]byte, error) {
+	return l.readFile(path)
+}
+
+func (l *mapLoader) stat(p string) error {
+	if _, ok := l.modules[p]; !ok {
+		return &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (l *mapLoader) readFile(p string) ([]byte, error) {
+	src, ok := l.modules[p]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	return []byte(src), nil
+}
+
+type chainLoader struct {
+	loaders []ModuleLoader
+	resolved *sync.Map // path -> the loader resolving it
+}
+
+// NewChainLoader returns a ModuleLoader trying loaders in order, a module is loaded
+// by the first loader which resolves it.
+func NewChainLoader(loaders ...ModuleLoader) ModuleLoader {
+	return &chainLoader{loaders: loaders, resolved: &sync.Map{}}
+}
+
+func (l *chainLoader) Resolve(id string, from string) (p string, err error) {
+	notFound := &ModuleNotFoundError{Id: id, From: from}
+	for _, loader := range l.loaders {
+		if p, err = loader.Resolve(id, from); err == nil {
+			l.resolved.Store(p, loader)
+			return
+		}
+		var e *ModuleNotFoundError
+		if !errors.As(err, &e) {
+			return
+		}
+		notFound.Tried = append(notFound.Tried, e.Tried...)
+		notFound.Err = e.Err
+	}
+	return "", notFound
+}
+
+func (l *chainLoader) Load(path string) (b []byte, err error) {
+	if loader, ok := l.resolved.Load(path); ok {
+		return loader.(ModuleLoader).Load(path)
+	}
+	err = fmt.Errorf("no module loader")
+	for _, loader := range l.loaders {
+		if b, err = loader.Load(path); err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (l *chainLoader) ModTime(path string) (time.Time, error) {
+	if loader, ok := l.resolved.Load(path); ok {
+		if mt, ok := loader.(ModuleModTimer); ok {
+			return mt.ModTime(path)
+		}
+	}
+	return time.Time{}, errNoModTime
+}
@@ -0,0 +1,70 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+var (
+	nativeModules = make(map[string]map[string]interface{}) // name -> exports
+	nativeModulesMu = &sync.RWMutex{}
+)
+
+// RegisterModule registers a Go-native module for all contexts. require(name) returns
+// exports wrapped as a Javascript object, Go values are wrapped as that of env in Eval.
+// Native modules are resolved before modules loaded by the ModuleLoader. A module
+// already required by a context is cached, re-registering it has no effect on the context.
+func RegisterModule(name string, exports map[string]interface{}) {
+	nativeModulesMu.Lock()
+	defer nativeModulesMu.Unlock()
+	nativeModules[name] = exports
+}
+
+// UnregisterModule removes the Go-native module registered by RegisterModule().
+func UnregisterModule(name string) {
+	nativeModulesMu.Lock()
+	defer nativeModulesMu.Unlock()
+	delete(nativeModules, name)
+}
+
+// RegisterModule registers a Go-native module for the context only, which takes
+// precedence over the module with the same name registered by djs.RegisterModule().
+// Contexts sharing the global object of the global heap share the cache of modules,
+// so the module registered by one of them is also returned to the others once required.
+func (ctx *JsContext) RegisterModule(name string, exports map[string]interface{}) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err := ctx.checkOpen(); err != nil {
+		return err
+	}
+
+	moduleConfsMu.Lock()
+	defer moduleConfsMu.Unlock()
+	conf, ok := moduleConfs[uintptr(unsafe.Pointer(ctx.c))]
+	if !ok {
+		return fmt.Errorf("no module settings of the context")
+	}
+	conf.natives[name] = exports
+	return nil
+}
+
+// getNativeModule returns the exports of the Go-native module registered for ctx, or for all contexts.
+func getNativeModule(ctx *C.duk_context, name string) (exports map[string]interface{}, ok bool) {
+	moduleConfsMu.RLock()
+	if conf, found := moduleConfs[uintptr(unsafe.Pointer(ctx))]; found {
+		exports, ok = conf.natives[name]
+	}
+	moduleConfsMu.RUnlock()
+	if ok {
+		return
+	}
+
+	nativeModulesMu.RLock()
+	defer nativeModulesMu.RUnlock()
+	exports, ok = nativeModules[name]
+	return
+}
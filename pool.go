@@ -0,0 +1,259 @@
+package djs
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// PoolOptions are the options to create a Pool.
+type PoolOptions struct {
+	Min int // number of contexts created in advance and kept when idle
+	Max int // max number of contexts, runtime.NumCPU() if it is not positive
+	IdleTimeout time.Duration // contexts more than Min are closed after being idle for IdleTimeout, 0 for never
+	Options []Option // options to create contexts
+	Scripts []string // script files evaluated after a context is created
+	Init func(*JsContext) error // called after Scripts are evaluated
+}
+
+// minReapInterval is the min interval of checking idle contexts, whatever IdleTimeout is.
+const minReapInterval = 10 * time.Millisecond
+
+type idleCtx struct {
+	ctx *JsContext
+	idleSince time.Time
+}
+
+// Pool is a pool of contexts with the same initial scripts, so that scripts can
+// be run concurrently without creating a context for every call.
+type Pool struct {
+	opts PoolOptions
+	slots chan struct{} // a slot is taken for every context in use
+	mu sync.Mutex
+	idle []*idleCtx
+	total int
+	closed bool
+	done chan struct{}
+}
+
+// NewPool creates a pool, and opts.Min contexts are created in advance.
+func NewPool(opts PoolOptions) (p *Pool, err error) {
+	if opts.Max <= 0 {
+		opts.Max = runtime.NumCPU()
+	}
+	if opts.Min > opts.Max {
+		err = fmt.Errorf("Min %d of pool is greater than Max %d", opts.Min, opts.Max)
+		return
+	}
+
+	p = &Pool{
+		opts: opts,
+		slots: make(chan struct{}, opts.Max),
+		done: make(chan struct{}),
+	}
+	for i:=0; i<opts.Min; i++ {
+		ctx, e := p.newContext()
+		if e != nil {
+			p.Close()
+			return nil, e
+		}
+		p.idle = append(p.idle, &idleCtx{ctx: ctx, idleSince: time.Now()})
+		p.total += 1
+	}
+	if opts.IdleTimeout > 0 {
+		go p.reap()
+	}
+	return
+}
+
+func (p *Pool) newContext() (ctx *JsContext, err error) {
+	if ctx, err = NewContext(p.opts.Options...); err != nil {
+		return
+	}
+	for _, script := range p.opts.Scripts {
+		if _, err = ctx.EvalFile(script, nil); err != nil {
+			ctx.Close()
+			return nil, err
+		}
+	}
+	if p.opts.Init != nil {
+		if err = p.opts.Init(ctx); err != nil {
+			ctx.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// Get takes a context from the pool, a new context is created if there's no idle one.
+// It blocks if Max contexts are in use, until one is returned or goCtx is done.
+// The context must be returned by Put() or Discard(). A nil goCtx is treated as
+// context.Background().
+func (p *Pool) Get(goCtx context.Context) (ctx *JsContext, err error) {
+	if goCtx == nil {
+		goCtx = context.Background()
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-goCtx.Done():
+		err = goCtx.Err()
+		return
+	case <-p.done:
+		err = fmt.Errorf("pool closed")
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		err = fmt.Errorf("pool closed")
+		return
+	}
+	if n := len(p.idle); n > 0 {
+		ctx = p.idle[n-1].ctx
+		p.idle[n-1] = nil
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return
+	}
+	p.total += 1
+	p.mu.Unlock()
+
+	if ctx, err = p.newContext(); err != nil {
+		p.mu.Lock()
+		p.total -= 1
+		p.mu.Unlock()
+		<-p.slots
+	}
+	return
+}
+
+// Put returns a context got from Get() to the pool.
+func (p *Pool) Put(ctx *JsContext) {
+	p.mu.Lock()
+	if p.closed || !ctx.isOpen() {
+		p.total -= 1
+		p.mu.Unlock()
+		ctx.Close()
+		<-p.slots
+		return
+	}
+	p.idle = append(p.idle, &idleCtx{ctx: ctx, idleSince: time.Now()})
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// Discard closes a context got from Get() instead of returning it to the pool,
+// e.g. when the context is in a bad state after an error or a timeout.
+func (p *Pool) Discard(ctx *JsContext) {
+	ctx.Close()
+	p.mu.Lock()
+	p.total -= 1
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// Do calls fn with a context from the pool. The context is returned to the pool
+// if fn returns nil, or discarded if fn returns an error or panics.
+func (p *Pool) Do(goCtx context.Context, fn func(*JsContext) error) (err error) {
+	ctx, err := p.Get(goCtx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p.Discard(ctx)
+			panic(r)
+		}
+	}()
+	if err = fn(ctx); err != nil {
+		p.Discard(ctx)
+		return
+	}
+	p.Put(ctx)
+	return
+}
+
+// Len returns the number of all contexts and the idle ones in the pool.
+func (p *Pool) Len() (total int, idle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total, len(p.idle)
+}
+
+// Close closes all idle contexts, contexts in use are closed when they are returned.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.total -= len(idle)
+	p.mu.Unlock()
+
+	close(p.done)
+	for _, c := range idle {
+		c.ctx.Close()
+	}
+}
+
+// reap closes the contexts idle for opts.IdleTimeout, and creates contexts if there
+// are less than opts.Min ones after some were discarded.
+func (p *Pool) reap() {
+	interval := p.opts.IdleTimeout / 2
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			var expired []*idleCtx
+			p.mu.Lock()
+			// p.idle is in the order of idleSince, the oldest first
+			for len(p.idle) > 0 && p.total > p.opts.Min && now.Sub(p.idle[0].idleSince) >= p.opts.IdleTimeout {
+				expired = append(expired, p.idle[0])
+				p.idle[0] = nil
+				p.idle = p.idle[1:]
+				p.total -= 1
+			}
+			need := p.opts.Min - p.total
+			if need > 0 {
+				p.total += need
+			}
+			p.mu.Unlock()
+
+			for _, c := range expired {
+				c.ctx.Close()
+			}
+			for i:=0; i<need; i++ {
+				p.refill()
+			}
+		}
+	}
+}
+
+func (p *Pool) refill() {
+	ctx, err := p.newContext()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil || p.closed {
+		p.total -= 1
+		if ctx != nil {
+			ctx.Close()
+		}
+		return
+	}
+	p.idle = append(p.idle, &idleCtx{ctx: ctx, idleSince: time.Now()})
+}
@@ -0,0 +1,147 @@
+package djs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolGrowShrink(t *testing.T) {
+	p, err := NewPool(PoolOptions{Min: 1, Max: 4, IdleTimeout: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var contexts []*JsContext
+	for i := 0; i < 4; i++ {
+		ctx, err := p.Get(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contexts = append(contexts, ctx)
+	}
+	if total, idle := p.Len(); total != 4 || idle != 0 {
+		t.Fatalf("Len() = %d, %d, want 4, 0", total, idle)
+	}
+	for _, ctx := range contexts {
+		p.Put(ctx)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		total, idle := p.Len()
+		if total == 1 && idle == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %d, %d, want the pool to shrink to Min", total, idle)
+		}
+		time.Sleep(minReapInterval)
+	}
+}
+
+func TestPoolConcurrentDo(t *testing.T) {
+	p, err := NewPool(PoolOptions{Max: 3, Init: func(ctx *JsContext) error {
+		_, err := ctx.Eval("function add(a, b) { return a + b }", nil)
+		return err
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- p.Do(context.Background(), func(ctx *JsContext) error {
+				res, err := ctx.CallFunc("add", i, 1)
+				if err == nil && res != float64(i+1) {
+					t.Errorf("add(%d, 1) = %v", i, res)
+				}
+				return err
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total, _ := p.Len(); total > 3 {
+		t.Fatalf("%d contexts created, more than Max", total)
+	}
+}
+
+func TestPoolDiscard(t *testing.T) {
+	p, err := NewPool(PoolOptions{Max: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var used *JsContext
+	errBad := errors.New("bad state")
+	if err = p.Do(nil, func(ctx *JsContext) error {
+		used = ctx
+		return errBad
+	}); !errors.Is(err, errBad) {
+		t.Fatalf("Do() returned %v, want the error of fn", err)
+	}
+	if used.isOpen() {
+		t.Fatal("the context is not discarded after an error")
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("recovered %v, want the panic of fn", r)
+			}
+		}()
+		p.Do(nil, func(ctx *JsContext) error {
+			used = ctx
+			panic("boom")
+		})
+	}()
+	if used.isOpen() {
+		t.Fatal("the context is not discarded after a panic")
+	}
+	if total, idle := p.Len(); total != 0 || idle != 0 {
+		t.Fatalf("Len() = %d, %d after discarding, want 0, 0", total, idle)
+	}
+
+	// the slot is free again
+	if err = p.Do(nil, func(ctx *JsContext) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolGetTimeout(t *testing.T) {
+	p, err := NewPool(PoolOptions{Max: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = p.Get(goCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() from a full pool returned %v, want DeadlineExceeded", err)
+	}
+	p.Put(ctx)
+	if ctx, err = p.Get(nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(ctx)
+}
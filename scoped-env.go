@@ -0,0 +1,117 @@
+package djs
+
+/*
+#include "duktape.h"
+duk_int_t pPutProp(duk_context *ctx);
+static duk_ret_t getEnv(duk_context *ctx, void *udata) {
+	// [ obj key ]
+	(void) udata;
+	duk_get_prop(ctx, -2);
+	return 1;
+}
+static duk_int_t pGetEnv(duk_context *ctx) {
+	return duk_safe_call(ctx, getEnv, NULL, 2, 1);
+}
+static duk_ret_t delEnv(duk_context *ctx, void *udata) {
+	// [ obj key ]
+	(void) udata;
+	duk_del_prop(ctx, -2);
+	return 0;
+}
+static duk_int_t pDelEnv(duk_context *ctx) {
+	return duk_safe_call(ctx, delEnv, NULL, 2, 1);
+}
+*/
+import "C"
+import (
+	"context"
+	"fmt"
+)
+
+// EvalScoped is the same as Eval, but vars in env are only visible during the
+// evaluation. After that, the global vars with the same names are restored to
+// their previous values, or removed if they didn't exist, even if an error occurs.
+func (ctx *JsContext) EvalScoped(script string, env map[string]interface{}) (res interface{}, err error) {
+	return ctx.EvalScopedContext(context.Background(), script, env)
+}
+
+// EvalScopedContext is the same as EvalScoped, but the script is aborted with a *TimeoutError
+// when goCtx is cancelled or its deadline passes.
+func (ctx *JsContext) EvalScopedContext(goCtx context.Context, script string, env map[string]interface{}) (res interface{}, err error) {
+	var cstr *C.char
+	var length C.int
+	getStrPtrLen(&script, &cstr, &length)
+	return ctx.eval(goCtx, "", "", cstr, length, env, true)
+}
+
+// setScopedEnv sets env as global vars, the previous values are saved in an object
+// left on the stack. restore must be called with the object at the same stack position,
+// it restores all the vars even if some of them fail, and returns the first error. The
+// vars are got and set in protected calls, as a getter or a read-only var throws an error.
+func setScopedEnv(ctx *C.duk_context, env map[string]interface{}) (restore func() error, err error) {
+	if len(env) == 0 {
+		return func() error { return nil }, nil
+	}
+
+	C.duk_push_bare_object(ctx) // [ saved ]
+	savedIdx := C.duk_get_top_index(ctx)
+	var keys []string // the vars set
+
+	restore = func() (err error) {
+		// [ saved ... ]
+		for _, k := range keys {
+			var rc C.duk_int_t
+			C.duk_push_global_object(ctx) // [ saved ... global ]
+			pushString(ctx, k) // [ saved ... global k ]
+			pushString(ctx, k) // [ saved ... global k k ]
+			if hasOwnProp(ctx, savedIdx) { // [ saved ... global k ]
+				pushString(ctx, k) // [ saved ... global k k ]
+				C.duk_get_prop(ctx, savedIdx) // [ saved ... global k old-v ]
+				rc = C.pPutProp(ctx) // [ saved ... result ] with global[k] = old-v
+			} else {
+				rc = C.pDelEnv(ctx) // [ saved ... result ] with global[k] deleted
+			}
+			if rc != 0 && err == nil { // [ saved ... error ]
+				err = fmt.Errorf("failed to restore global %s: %w", k, getJsError(ctx))
+			}
+			C.duk_pop(ctx) // [ saved ... ]
+		}
+		C.duk_remove(ctx, savedIdx) // [ ... ]
+		return
+	}
+
+	for k, v := range env {
+		C.duk_push_global_object(ctx) // [ saved global ]
+		pushString(ctx, k) // [ saved global k ]
+		C.duk_dup(ctx, -1) // [ saved global k k ]
+		if hasOwnProp(ctx, -3) { // [ saved global k ]
+			if C.pGetEnv(ctx) != 0 { // [ saved error ]
+				err = fmt.Errorf("failed to get global %s: %w", k, getJsError(ctx))
+				C.duk_pop(ctx) // [ saved ]
+				break
+			}
+			// [ saved old-v ]
+			pushString(ctx, k) // [ saved old-v k ]
+			C.duk_swap_top(ctx, -2) // [ saved k old-v ]
+			C.duk_put_prop(ctx, savedIdx) // [ saved ] with saved[k] = old-v
+		} else {
+			C.duk_pop_n(ctx, 2) // [ saved ]
+		}
+
+		C.duk_push_global_object(ctx) // [ saved global ]
+		pushString(ctx, k) // [ saved global k ]
+		pushJsProxyValue(ctx, v) // [ saved global k v ]
+		if C.pPutProp(ctx) != 0 { // [ saved error ]
+			err = fmt.Errorf("failed to set env %s: %w", k, getJsError(ctx))
+			C.duk_pop(ctx) // [ saved ]
+			break
+		}
+		C.duk_pop(ctx) // [ saved ] with global[k] = v
+		keys = append(keys, k)
+	}
+	if err != nil {
+		restore()
+		return nil, err
+	}
+	return
+}
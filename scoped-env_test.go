@@ -0,0 +1,56 @@
+package djs
+
+import (
+	"testing"
+)
+
+func TestEvalScoped(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	if _, err = ctx.Eval("var a = 1;", nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.EvalScoped("a + b", map[string]interface{}{"a": 10, "b": 20})
+	if err != nil || res != float64(30) {
+		t.Fatalf("EvalScoped() = %v, %v", res, err)
+	}
+	if res, err = ctx.Eval("[a, typeof b].join()", nil); err != nil || res != "1,undefined" {
+		t.Fatalf("vars after EvalScoped() = %v, %v", res, err)
+	}
+
+	// restored even if the script fails
+	if _, err = ctx.EvalScoped("throw new Error(a)", map[string]interface{}{"a": 10}); err == nil {
+		t.Fatal("EvalScoped() didn't fail")
+	}
+	if res, err = ctx.Eval("a", nil); err != nil || res != float64(1) {
+		t.Fatalf("a = %v, %v", res, err)
+	}
+}
+
+func TestEvalScopedReadOnly(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	if err = ctx.SetGlobal("api", 1, GlobalReadOnly()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.EvalScoped("api", map[string]interface{}{"b": 2, "api": 2, "undefined": 3}); err == nil {
+		t.Fatal("EvalScoped() with env of a read-only global succeeded")
+	}
+	if res, err := ctx.Eval("[api, typeof b].join()", nil); err != nil || res != "1,undefined" {
+		t.Fatalf("vars after EvalScoped() = %v, %v", res, err)
+	}
+
+	// the var can't be restored
+	script := "Object.defineProperty(this, 'k', {value: 5, writable: false, configurable: false}); k"
+	if _, err = ctx.EvalScoped(script, map[string]interface{}{"k": 1}); err == nil {
+		t.Fatal("EvalScoped() didn't report the failure of restoring")
+	}
+}
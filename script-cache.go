@@ -0,0 +1,757 @@
+package djs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ScriptCacheOptions are the options to create a ScriptCache.
+type ScriptCacheOptions struct {
+	MaxEntries int // max number of cached scripts, the least recently used one is evicted if exceeded, 0 for no limit
+	TTL time.Duration // cached scripts are evicted TTL after their contexts are created, 0 for never
+	Options []Option // options to create contexts, followed by the options passed to Load()
+	RefreshVars bool // set the vars passed to Load() to the cached context by SetGlobals(), instead of ignoring them
+	CloseLoaded bool // close the contexts returned by Load() too when they are replaced or evicted, they must not be used after that
+	Watch bool // watch the script files and module files on the local disk instead of checking them by every Load()
+	PollInterval time.Duration // interval to check the watched files if inotify is not available, 1s if 0
+	OnReload func(ReloadEvent) // called after a context is recreated or failed to be recreated
+}
+
+// ReloadEvent reports a context recreated because its script or modules changed.
+type ReloadEvent struct {
+	Path    string
+	Variant string
+	Reason  string // the file changed
+	Version int    // the version in use after the reload, the last good one if failed
+	Err     error  // nil if the context was recreated
+}
+
+// ScriptCacheStats are the statistics of a ScriptCache.
+type ScriptCacheStats struct {
+	Hits      uint64 // Load() returning a cached context
+	Misses    uint64 // Load() creating a context for a script not cached
+	Reloads   uint64 // Load() recreating a context because the script or its modules changed
+	ReloadErrors uint64 // reloads failed, the last good contexts are still used
+	Evictions uint64 // contexts removed because of MaxEntries or TTL
+	Entries   int    // number of cached scripts
+}
+
+// CacheInfo is the diagnostic information of a cached script.
+type CacheInfo struct {
+	Path         string
+	Variant      string    // the variant passed to LoadVariant(), or the hash of the options passed to Load()
+	Deps         []string  // the script file and the module files loaded by it
+	LoadedAt     time.Time // when the context was created
+	ReloadReason string    // why the context was recreated last time, empty if never recreated
+	Version      int       // 1 for the first context, increased by every reload
+	ReloadError  error     // the error of the last reload if it failed, the context of Version is still used
+	WatchError   error     // the error of watching the files if any failed, those files are checked by every Load() instead
+}
+
+type jsCtx struct {
+	jsvm *JsContext
+	script moduleDep // the script file
+	loadedAt time.Time
+	reloadReason string
+	version int
+	refs int // number of ScriptRef using the context, guarded by ScriptCache.mu
+	shared bool // returned by Load(), which is never released nor closed by the cache unless CloseLoaded, guarded by ScriptCache.mu
+	closeOnRelease bool // removed from the cache, closed by the last ScriptRef.Release(), guarded by ScriptCache.mu
+}
+
+// ScriptRef is a version of a cached context acquired by ScriptCache.Acquire(). The context
+// is not closed by the cache, even if it is replaced by a new version or evicted, until
+// Release() is called.
+type ScriptRef struct {
+	Ctx *JsContext
+	Existing bool // false if the context is created by Acquire()
+	c *ScriptCache
+	jsC *jsCtx
+	once sync.Once
+}
+
+type cacheEntry struct {
+	key string // key of ScriptCache.entries
+	path string
+	variant string
+	mu sync.Mutex // held when the context is being checked or created
+	jsC *jsCtx // the current version, nil if the context is not created
+	versions int // number of versions created
+	reloadErr error // the error of the last reload if it failed
+	failed map[string]string // path -> stamp of the deps when the last reload failed
+	refs int // number of Load() using the entry, guarded by ScriptCache.mu
+	vars map[string]interface{} // vars and options which created the context, to recreate it when watched files change
+	options []Option
+	watchedDeps int // number of the deps of jsC being watched
+	unwatched map[string]bool // deps of jsC on the local disk failed to be watched, checked by every Load()
+	watchErr error // the error of the last failed watch
+	watched map[string]bool // paths being watched for the entry, guarded by ScriptCache.mu
+	dirty string // the watched file changed, guarded by ScriptCache.mu
+}
+
+// ScriptCache caches the contexts which evaluated script files. A context is recreated
+// if its script file or any module loaded by it is changed.
+type ScriptCache struct {
+	opts ScriptCacheOptions
+	mu sync.Mutex
+	entries map[string]*list.Element // path and variant -> element of *cacheEntry in lru
+	lru *list.List // the most recently used first
+	closed bool
+	done chan struct{}
+	hits, misses, reloads, reloadErrors, evictions uint64
+	watcher fileWatcher // nil if not Watch
+	watched map[string]map[*cacheEntry]bool // path -> entries depending on it
+}
+
+// NewScriptCache creates a ScriptCache.
+func NewScriptCache(opts ScriptCacheOptions) *ScriptCache {
+	c := &ScriptCache{
+		opts: opts,
+		entries: make(map[string]*list.Element),
+		lru: list.New(),
+		done: make(chan struct{}),
+	}
+	if opts.TTL > 0 {
+		go c.expire()
+	}
+	if opts.Watch {
+		c.watched = make(map[string]map[*cacheEntry]bool)
+		c.watcher = newFileWatcher(c.fileChanged, opts.PollInterval)
+	}
+	return c
+}
+
+// Load returns the context which evaluated the script file path with vars. The context
+// is created if the script is not cached or it is changed, in which case existing is false.
+// Loading different scripts doesn't block each other, and concurrent calls for the same
+// script wait for the same context to be created. Calls with different options get different
+// contexts, while vars are ignored if the context is cached unless RefreshVars is set.
+// With Watch set, the files on the local disk are not checked by Load(), the contexts are
+// recreated in background when they are changed.
+// As the cache can't know when the returned context is not used any more, it's never closed
+// by the cache, even if it's replaced, evicted or the cache is closed, it's freed when not
+// reachable. Use Acquire() to close the contexts deterministically, or set CloseLoaded if
+// the returned contexts are not kept after the next Load().
+func (c *ScriptCache) Load(path string, vars map[string]interface{}, options ...Option) (ctx *JsContext, existing bool, err error) {
+	return c.LoadVariant(path, optionsKey(options), vars, options...)
+}
+
+// LoadVariant is the same as Load, but the contexts of path are distinguished by variant
+// instead of the options, e.g. a tenant id, so that callers with different variants don't
+// share a context.
+func (c *ScriptCache) LoadVariant(path string, variant string, vars map[string]interface{}, options ...Option) (ctx *JsContext, existing bool, err error) {
+	jsC, existing, err := c.get(path, variant, vars, options, false)
+	if err != nil {
+		return
+	}
+	return jsC.jsvm, existing, nil
+}
+
+// Acquire is the same as Load, but the context is referenced by the returned ScriptRef,
+// which must be released by Release(). A context replaced by a new version when the script
+// changed, or evicted, keeps working until released, and then it's closed. The contexts
+// also returned by Load() are never closed by the cache unless CloseLoaded is set, they are
+// freed when not reachable.
+func (c *ScriptCache) Acquire(path string, vars map[string]interface{}, options ...Option) (*ScriptRef, error) {
+	return c.AcquireVariant(path, optionsKey(options), vars, options...)
+}
+
+// AcquireVariant is the same as Acquire, but the contexts are distinguished by variant
+// as LoadVariant.
+func (c *ScriptCache) AcquireVariant(path string, variant string, vars map[string]interface{}, options ...Option) (*ScriptRef, error) {
+	jsC, existing, err := c.get(path, variant, vars, options, true)
+	if err != nil {
+		return nil, err
+	}
+	return &ScriptRef{Ctx: jsC.jsvm, Existing: existing, c: c, jsC: jsC}, nil
+}
+
+// Do calls fn with the context acquired by Acquire(), and releases it after fn returns.
+func (c *ScriptCache) Do(path string, vars map[string]interface{}, fn func(*JsContext) error, options ...Option) (err error) {
+	ref, err := c.Acquire(path, vars, options...)
+	if err != nil {
+		return
+	}
+	defer ref.Release()
+	return fn(ref.Ctx)
+}
+
+// Release releases the context, which is closed if it has been replaced or evicted and
+// no one else is using it. It's safe to call Release() more than once.
+func (r *ScriptRef) Release() {
+	r.once.Do(func() {
+		r.c.mu.Lock()
+		r.jsC.refs -= 1
+		closing := r.jsC.closeOnRelease && r.jsC.refs == 0
+		r.c.mu.Unlock()
+
+		if closing {
+			r.jsC.jsvm.Close()
+		}
+	})
+}
+
+// get returns the current version of the context, which is referenced if ref is set, or
+// marked shared otherwise. If the script changed and the reload failed, the last good
+// version is returned and the error is reported by OnReload and Info().
+func (c *ScriptCache) get(path string, variant string, vars map[string]interface{}, options []Option, ref bool) (jsC *jsCtx, existing bool, err error) {
+	e, err := c.acquire(path, variant)
+	if err != nil {
+		return
+	}
+	defer c.release(e)
+
+	var ev *ReloadEvent
+	defer func() {
+		// called after e.mu is unlocked
+		if ev != nil {
+			c.report(*ev)
+		}
+	}()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.jsC != nil && c.expired(e.jsC, time.Now()) {
+		e.drop(c)
+		atomic.AddUint64(&c.evictions, 1)
+	}
+
+	if e.jsC == nil {
+		atomic.AddUint64(&c.misses, 1)
+		if err = c.create(e, vars, c.options(options), ""); err != nil {
+			return
+		}
+	} else if reason, changed := c.changed(e); changed {
+		atomic.AddUint64(&c.reloads, 1)
+		ev = &ReloadEvent{Path: path, Variant: variant, Reason: reason}
+		ev.Err = c.create(e, vars, c.options(options), reason)
+		ev.Version = e.jsC.version
+		existing = ev.Err != nil
+	} else {
+		existing = true
+	}
+
+	if existing {
+		atomic.AddUint64(&c.hits, 1)
+		if c.opts.RefreshVars && len(vars) > 0 {
+			if err = e.jsC.jsvm.SetGlobals(vars); err != nil {
+				return
+			}
+		}
+	}
+
+	jsC = e.jsC
+	c.mu.Lock()
+	if ref {
+		jsC.refs += 1
+	} else {
+		jsC.shared = true
+	}
+	c.mu.Unlock()
+	return
+}
+
+// create creates a new version of the context of the entry, with e.mu held. The current
+// version is replaced only if the new one is created, otherwise it's kept and the failure
+// is recorded, so that it's not reloaded again until the files change again. The watched
+// files changed after the dirty flag is cleared will make the entry dirty again.
+func (c *ScriptCache) create(e *cacheEntry, vars map[string]interface{}, options []Option, reason string) error {
+	c.mu.Lock()
+	e.dirty = ""
+	c.mu.Unlock()
+
+	jsC, err := createJsCtx(e.path, vars, options...)
+	if err != nil {
+		if e.jsC != nil {
+			atomic.AddUint64(&c.reloadErrors, 1)
+			e.reloadErr = err
+			e.failed = e.jsC.stamps()
+		}
+		return err
+	}
+	e.versions += 1
+	jsC.version, jsC.reloadReason = e.versions, reason
+	e.reloadErr, e.failed = nil, nil
+
+	old := e.jsC
+	e.jsC, e.vars, e.options = jsC, vars, options
+	e.watchedDeps, e.unwatched, e.watchErr = 0, nil, nil
+	c.mu.Lock()
+	closing := old != nil && c.retire(old)
+	c.unwatchEntry(e)
+	c.mu.Unlock()
+	if closing {
+		old.jsvm.Close()
+	}
+	c.watchDeps(e)
+	return nil
+}
+
+// retire marks a version replaced by a new one or removed from the cache, with c.mu held.
+// It reports whether the context should be closed now, otherwise it's closed by the last
+// ScriptRef.Release(), or left to the GC if it has been returned by Load(), which may be still
+// using it, unless CloseLoaded is set.
+func (c *ScriptCache) retire(jsC *jsCtx) bool {
+	if jsC.shared && !c.opts.CloseLoaded {
+		return false
+	}
+	jsC.closeOnRelease = true
+	return jsC.refs == 0
+}
+
+// changed checks the entry with e.mu held.
+func (c *ScriptCache) changed(e *cacheEntry) (reason string, changed bool) {
+	if c.watcher == nil {
+		return e.jsC.changed(nil, e.failed)
+	}
+	c.mu.Lock()
+	reason = e.dirty
+	c.mu.Unlock()
+	if len(reason) > 0 {
+		return reason, true
+	}
+	// modules loaded after the context was created, e.g. by a function called later
+	c.watchDeps(e)
+	return e.jsC.changed(func(dep moduleDep) bool {
+		return dep.isFile() && !e.unwatched[dep.path]
+	}, e.failed)
+}
+
+// watchDeps watches the files of the context not watched yet, with e.mu held. The files
+// failed to be watched are recorded in e.unwatched, which are checked by changed().
+func (c *ScriptCache) watchDeps(e *cacheEntry) {
+	if c.watcher == nil {
+		return
+	}
+	deps := e.jsC.deps()
+	if e.watchedDeps == len(deps) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	for _, dep := range deps[e.watchedDeps:] {
+		if !dep.isFile() {
+			continue
+		}
+		path, err := filepath.Abs(dep.path)
+		if err == nil && e.watched[path] {
+			continue
+		}
+		entries, ok := c.watched[path]
+		if err == nil && !ok {
+			err = c.watcher.watch(path)
+		}
+		if err != nil {
+			if e.unwatched == nil {
+				e.unwatched = make(map[string]bool)
+			}
+			e.unwatched[dep.path] = true
+			e.watchErr = fmt.Errorf("failed to watch %s: %v", dep.path, err)
+			continue
+		}
+		if !ok {
+			entries = make(map[*cacheEntry]bool)
+			c.watched[path] = entries
+		}
+		entries[e] = true
+		if e.watched == nil {
+			e.watched = make(map[string]bool)
+		}
+		e.watched[path] = true
+	}
+	e.watchedDeps = len(deps)
+}
+
+// unwatchEntry stops watching the files of the entry which no other entries depend on,
+// with c.mu held.
+func (c *ScriptCache) unwatchEntry(e *cacheEntry) {
+	if c.watcher == nil {
+		return
+	}
+	for path := range e.watched {
+		if entries, ok := c.watched[path]; ok {
+			delete(entries, e)
+			if len(entries) == 0 {
+				delete(c.watched, path)
+				c.watcher.unwatch(path)
+			}
+		}
+	}
+	e.watched = nil
+}
+
+// fileChanged is called by the watcher, it marks the entries depending on path dirty
+// and recreates their contexts in background.
+func (c *ScriptCache) fileChanged(path string) {
+	var entries []*cacheEntry
+	c.mu.Lock()
+	for e := range c.watched[path] {
+		if len(e.dirty) == 0 {
+			entries = append(entries, e)
+		}
+		e.dirty = fmt.Sprintf("%s changed", path)
+	}
+	c.mu.Unlock()
+
+	for _, e := range entries {
+		go c.refresh(e)
+	}
+}
+
+// reloadDelay is the time to wait for the writing of a changed file to complete.
+const reloadDelay = 100 * time.Millisecond
+
+// refresh recreates the context of a dirty entry with the vars and options which created it.
+func (c *ScriptCache) refresh(e *cacheEntry) {
+	time.Sleep(reloadDelay)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[e.key]; c.closed || !ok || elem.Value != e {
+		// removed from the cache
+		c.mu.Unlock()
+		return
+	}
+	e.refs += 1
+	c.mu.Unlock()
+	defer c.release(e)
+
+	var ev *ReloadEvent
+	e.mu.Lock()
+	if e.jsC != nil {
+		c.mu.Lock()
+		reason := e.dirty
+		c.mu.Unlock()
+		if len(reason) > 0 {
+			atomic.AddUint64(&c.reloads, 1)
+			ev = &ReloadEvent{Path: e.path, Variant: e.variant, Reason: reason}
+			ev.Err = c.create(e, e.vars, e.options, reason)
+			ev.Version = e.jsC.version
+		}
+	}
+	e.mu.Unlock()
+
+	if ev != nil {
+		c.report(*ev)
+	}
+}
+
+func (c *ScriptCache) report(ev ReloadEvent) {
+	if c.opts.OnReload != nil {
+		c.opts.OnReload(ev)
+	}
+}
+
+// Info returns the diagnostic information of all the cached variants of the script path.
+func (c *ScriptCache) Info(path string) (infos []*CacheInfo) {
+	var entries []*cacheEntry
+	c.mu.Lock()
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		if e := elem.Value.(*cacheEntry); e.path == path {
+			entries = append(entries, e)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, e := range entries {
+		e.mu.Lock()
+		if e.jsC != nil {
+			info := e.jsC.info()
+			info.Variant = e.variant
+			info.ReloadError = e.reloadErr
+			info.WatchError = e.watchErr
+			infos = append(infos, info)
+		}
+		e.mu.Unlock()
+	}
+	return
+}
+
+// Stats returns the statistics of the cache.
+func (c *ScriptCache) Stats() ScriptCacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return ScriptCacheStats{
+		Hits: atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Reloads: atomic.LoadUint64(&c.reloads),
+		ReloadErrors: atomic.LoadUint64(&c.reloadErrors),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Entries: entries,
+	}
+}
+
+// Close closes all the cached contexts, those being loaded are closed after loaded, and
+// those acquired are closed when released. The contexts returned by Load() are left to the GC
+// unless CloseLoaded is set.
+// Load() will fail after Close().
+func (c *ScriptCache) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	var closing []*cacheEntry
+	for key, elem := range c.entries {
+		e := elem.Value.(*cacheEntry)
+		if e.refs == 0 {
+			closing = append(closing, e)
+		}
+		c.unwatchEntry(e)
+		delete(c.entries, key)
+	}
+	c.lru.Init()
+	c.mu.Unlock()
+
+	close(c.done)
+	if c.watcher != nil {
+		c.watcher.close()
+	}
+	for _, e := range closing {
+		e.close(c)
+	}
+}
+
+// acquire returns the entry of path and variant, which is created if not existing, and
+// marks it the most recently used. The entry must be released by release().
+func (c *ScriptCache) acquire(path string, variant string) (e *cacheEntry, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		err = fmt.Errorf("script cache closed")
+		return
+	}
+	key := cacheKey(path, variant)
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		e = elem.Value.(*cacheEntry)
+	} else {
+		e = &cacheEntry{key: key, path: path, variant: variant}
+		c.entries[key] = c.lru.PushFront(e)
+	}
+	e.refs += 1
+	return
+}
+
+// release releases the entry got by acquire(), and evicts the least recently used
+// entries not in use if there are more than MaxEntries.
+func (c *ScriptCache) release(e *cacheEntry) {
+	var evicted []*cacheEntry
+
+	c.mu.Lock()
+	e.refs -= 1
+	if c.closed {
+		c.mu.Unlock()
+		if e.refs == 0 {
+			// removed by Close()
+			e.close(c)
+		}
+		return
+	}
+	if elem, ok := c.entries[e.key]; ok && elem.Value == e && e.refs == 0 && e.jsC == nil {
+		// failed to create the context
+		c.unwatchEntry(e)
+		c.lru.Remove(elem)
+		delete(c.entries, e.key)
+	}
+	if c.opts.MaxEntries > 0 {
+		for elem := c.lru.Back(); elem != nil && c.lru.Len() > c.opts.MaxEntries; {
+			prev := elem.Prev()
+			if ev := elem.Value.(*cacheEntry); ev.refs == 0 {
+				c.unwatchEntry(ev)
+				c.lru.Remove(elem)
+				delete(c.entries, ev.key)
+				evicted = append(evicted, ev)
+			}
+			elem = prev
+		}
+	}
+	c.mu.Unlock()
+
+	for _, ev := range evicted {
+		if ev.close(c) {
+			atomic.AddUint64(&c.evictions, 1)
+		}
+	}
+}
+
+func cacheKey(path string, variant string) string {
+	if len(variant) == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s\x00%s", path, variant)
+}
+
+// optionsKey returns the hash of the options applied, or "" if there's no option.
+// Options referencing the same loader, writers or logger have the same hash.
+func optionsKey(options []Option) string {
+	if len(options) == 0 {
+		return ""
+	}
+	o := getOptions(options...)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", *o)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c *ScriptCache) options(options []Option) []Option {
+	if len(c.opts.Options) == 0 {
+		return options
+	}
+	return append(append([]Option{}, c.opts.Options...), options...)
+}
+
+func (c *ScriptCache) expired(jsC *jsCtx, now time.Time) bool {
+	return c.opts.TTL > 0 && now.Sub(jsC.loadedAt) >= c.opts.TTL
+}
+
+// expire evicts the entries not in use which are expired, checked at the same min
+// interval as the idle contexts of Pool.
+func (c *ScriptCache) expire() {
+	interval := c.opts.TTL / 2
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			var expired []*cacheEntry
+			c.mu.Lock()
+			for key, elem := range c.entries {
+				// e.jsC is not changed when e.refs is 0
+				if e := elem.Value.(*cacheEntry); e.refs == 0 && e.jsC != nil && c.expired(e.jsC, now) {
+					c.unwatchEntry(e)
+					c.lru.Remove(elem)
+					delete(c.entries, key)
+					expired = append(expired, e)
+				}
+			}
+			c.mu.Unlock()
+
+			for _, e := range expired {
+				if e.close(c) {
+					atomic.AddUint64(&c.evictions, 1)
+				}
+			}
+		}
+	}
+}
+
+// close closes the context of an entry removed from the cache, it reports whether
+// there is a context. The context being acquired is closed when released.
+func (e *cacheEntry) close(c *ScriptCache) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.jsC == nil {
+		return false
+	}
+	e.drop(c)
+	return true
+}
+
+// drop removes the current version of the context, with e.mu held.
+func (e *cacheEntry) drop(c *ScriptCache) {
+	jsC := e.jsC
+	e.jsC, e.reloadErr, e.failed = nil, nil, nil
+	c.mu.Lock()
+	closing := c.retire(jsC)
+	c.mu.Unlock()
+	if closing {
+		jsC.jsvm.Close()
+	}
+}
+
+func createJsCtx(path string, vars map[string]interface{}, options ...Option) (jsC *jsCtx, err error) {
+	// the stamp is taken before evaluating, so that a change when evaluating will be detected
+	script, err := newModuleDep(path, nil, nil)
+	if err != nil {
+		return
+	}
+	ctx, err := createJSContext(path, vars, options...)
+	if err != nil {
+		if ctx != nil {
+			ctx.Close()
+		}
+		return
+	}
+	jsC = &jsCtx{
+		jsvm: ctx,
+		script: script,
+		loadedAt: time.Now(),
+	}
+	return
+}
+
+// deps returns the script file and the module files loaded by the context.
+func (jsC *jsCtx) deps() []moduleDep {
+	return append([]moduleDep{jsC.script}, jsC.jsvm.moduleDeps()...)
+}
+
+// changed checks the script file and the module files loaded by the context, the files
+// being watched are skipped. The files with the stamps in failed are regarded as unchanged,
+// as they failed to be reloaded.
+func (jsC *jsCtx) changed(watched func(moduleDep) bool, failed map[string]string) (reason string, changed bool) {
+	for _, dep := range jsC.deps() {
+		if watched != nil && watched(dep) {
+			continue
+		}
+		stamp, err := dep.current()
+		if err == nil && stamp == dep.stamp {
+			continue
+		}
+		if s, ok := failed[dep.path]; ok && s == stamp {
+			continue
+		}
+		if err != nil {
+			return fmt.Sprintf("%s: %v", dep.path, err), true
+		}
+		return fmt.Sprintf("%s changed", dep.path), true
+	}
+	return
+}
+
+// stamps returns the current stamps of the files loaded by the context, "" for those
+// failed to be checked.
+func (jsC *jsCtx) stamps() map[string]string {
+	stamps := make(map[string]string)
+	for _, dep := range jsC.deps() {
+		stamp, _ := dep.current()
+		stamps[dep.path] = stamp
+	}
+	return stamps
+}
+
+func (jsC *jsCtx) info() *CacheInfo {
+	info := &CacheInfo{
+		Path: jsC.script.path,
+		Deps: []string{jsC.script.path},
+		LoadedAt: jsC.loadedAt,
+		ReloadReason: jsC.reloadReason,
+		Version: jsC.version,
+	}
+	seen := map[string]bool{jsC.script.path: true}
+	for _, dep := range jsC.jsvm.moduleDeps() {
+		if !seen[dep.path] {
+			seen[dep.path] = true
+			info.Deps = append(info.Deps, dep.path)
+		}
+	}
+	return info
+}
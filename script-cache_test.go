@@ -0,0 +1,353 @@
+package djs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScriptCacheEvictLoaded(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.js"), filepath.Join(dir, "b.js")
+	writeScript(t, a, "function f() { return 'a' }")
+	writeScript(t, b, "function f() { return 'b' }")
+
+	c := NewScriptCache(ScriptCacheOptions{MaxEntries: 1})
+	ctxA, _, err := c.Load(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := c.Acquire(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = c.Load(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 1 {
+		t.Fatalf("Stats() = %+v, want 1 eviction and 1 entry", stats)
+	}
+
+	// the evicted context returned by Load() is still usable, even after released by a ref
+	ref.Release()
+	if res, err := ctxA.CallFunc("f"); err != nil || res != "a" {
+		t.Fatalf("CallFunc() of the evicted context = %v, %v", res, err)
+	}
+	c.Close()
+	if res, err := ctxA.CallFunc("f"); err != nil || res != "a" {
+		t.Fatalf("CallFunc() after the cache closed = %v, %v", res, err)
+	}
+}
+
+func TestScriptCacheEvictAcquired(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.js"), filepath.Join(dir, "b.js")
+	writeScript(t, a, "function f() { return 'a' }")
+	writeScript(t, b, "function f() { return 'b' }")
+
+	c := NewScriptCache(ScriptCacheOptions{MaxEntries: 1})
+	defer c.Close()
+	ref, err := c.Acquire(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Do(b, nil, func(ctx *JsContext) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ref.Ctx.CallFunc("f"); err != nil || res != "a" {
+		t.Fatalf("CallFunc() of the evicted context = %v, %v", res, err)
+	}
+	ref.Release()
+	if ref.Ctx.isOpen() {
+		t.Fatal("the evicted context is not closed after released")
+	}
+}
+
+func TestScriptCacheWatchReload(t *testing.T) {
+	dir := t.TempDir()
+	main, m := filepath.Join(dir, "main.js"), filepath.Join(dir, "m.js")
+	writeScript(t, m, "exports.v = 1")
+	writeScript(t, main, "var v = require('./m').v")
+
+	reloaded := make(chan ReloadEvent, 4)
+	c := NewScriptCache(ScriptCacheOptions{
+		Watch: true,
+		PollInterval: 10 * time.Millisecond,
+		OnReload: func(ev ReloadEvent) {
+			select {
+			case reloaded <- ev:
+			default:
+			}
+		},
+	})
+	defer c.Close()
+	ctx, _, err := c.Load(main, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ctx.GetGlobal("v"); err != nil || res != float64(1) {
+		t.Fatalf("v = %v, %v", res, err)
+	}
+
+	// the module replaced by renaming, the context is recreated in background
+	writeScript(t, m + ".tmp", "exports.v = 22")
+	if err = os.Rename(m + ".tmp", m); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-reloaded:
+		if ev.Err != nil || ev.Version != 2 || !strings.HasPrefix(ev.Reason, m) {
+			t.Fatalf("reload event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change of the module is not noticed")
+	}
+	ctx, existing, err := c.Load(main, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ctx.GetGlobal("v"); !existing || err != nil || res != float64(22) {
+		t.Fatalf("v = %v, %v after reloaded, existing: %v", res, err, existing)
+	}
+}
+
+func TestScriptCacheSingleFlight(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.js")
+	writeScript(t, main, "init()")
+
+	var created int32
+	c := NewScriptCache(ScriptCacheOptions{})
+	defer c.Close()
+	init := func() {
+		// slow down the creation so that the calls overlap
+		atomic.AddInt32(&created, 1)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	contexts := make([]*JsContext, 8)
+	for i := range contexts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, _, err := c.Load(main, map[string]interface{}{"init": init})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			contexts[i] = ctx
+		}(i)
+	}
+	wg.Wait()
+	for _, ctx := range contexts[1:] {
+		if ctx != contexts[0] {
+			t.Fatal("concurrent Load() created different contexts")
+		}
+	}
+	if created != 1 {
+		t.Fatalf("the script is evaluated %d times", created)
+	}
+	if stats := c.Stats(); stats.Misses != 1 || stats.Hits != uint64(len(contexts)-1) {
+		t.Fatalf("Stats() = %+v, want 1 miss", stats)
+	}
+}
+
+func TestScriptCacheLRU(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.js", "b.js", "c.js"} {
+		path := filepath.Join(dir, name)
+		writeScript(t, path, "")
+		paths = append(paths, path)
+	}
+
+	c := NewScriptCache(ScriptCacheOptions{MaxEntries: 2})
+	defer c.Close()
+	load := func(path string) bool {
+		_, existing, err := c.Load(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return existing
+	}
+	load(paths[0])
+	load(paths[1])
+	load(paths[0]) // b.js is the least recently used
+	load(paths[2])
+	if !load(paths[0]) || load(paths[1]) {
+		t.Fatal("the least recently used script is not evicted")
+	}
+	if stats := c.Stats(); stats.Evictions != 2 || stats.Entries != 2 {
+		t.Fatalf("Stats() = %+v, want 2 evictions and 2 entries", stats)
+	}
+}
+
+func TestScriptCacheVersions(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.js")
+	writeScript(t, main, "function v() { return 1 }")
+
+	c := NewScriptCache(ScriptCacheOptions{})
+	defer c.Close()
+	ref, err := c.Acquire(main, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new version replaces the acquired one, which keeps working until released
+	writeScript(t, main, "function v() { return 2 } // changed")
+	if err = c.Do(main, nil, func(ctx *JsContext) error {
+		res, err := ctx.CallFunc("v")
+		if err == nil && res != float64(2) {
+			t.Errorf("v() of the new version = %v", res)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ref.Ctx.CallFunc("v"); err != nil || res != float64(1) {
+		t.Fatalf("v() of the replaced version = %v, %v", res, err)
+	}
+	ref.Release()
+	if ref.Ctx.isOpen() {
+		t.Fatal("the replaced version is not closed after released")
+	}
+
+	// the last good version is used if the reload fails
+	writeScript(t, main, "function v( { // broken")
+	ctx, existing, err := c.Load(main, nil)
+	if err != nil || !existing {
+		t.Fatalf("Load() of a broken script = %v, %v, want the last good version", existing, err)
+	}
+	if res, err := ctx.CallFunc("v"); err != nil || res != float64(2) {
+		t.Fatalf("v() of the last good version = %v, %v", res, err)
+	}
+	infos := c.Info(main)
+	if len(infos) != 1 || infos[0].Version != 2 || infos[0].ReloadError == nil {
+		t.Fatalf("Info() = %+v, want version 2 with the reload error", infos)
+	}
+	if stats := c.Stats(); stats.Reloads != 2 || stats.ReloadErrors != 1 {
+		t.Fatalf("Stats() = %+v, want 2 reloads and 1 error", stats)
+	}
+
+	// not reloaded again until the file changes again
+	if _, _, err = c.Load(main, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats.Reloads != 2 {
+		t.Fatalf("Stats() = %+v, the broken script is reloaded again", stats)
+	}
+	writeScript(t, main, "function v() { return 3 } // fixed again")
+	if ctx, existing, err = c.Load(main, nil); err != nil || existing {
+		t.Fatalf("Load() of the fixed script = %v, %v", existing, err)
+	}
+	if res, err := ctx.CallFunc("v"); err != nil || res != float64(3) {
+		t.Fatalf("v() of version 3 = %v, %v", res, err)
+	}
+}
+
+// failingWatcher fails to watch the file fail.
+type failingWatcher struct {
+	fileWatcher
+	fail string
+}
+
+func (w *failingWatcher) watch(path string) error {
+	if path == w.fail {
+		return os.ErrPermission
+	}
+	return w.fileWatcher.watch(path)
+}
+
+func TestScriptCacheWatchFailed(t *testing.T) {
+	dir := t.TempDir()
+	main, m := filepath.Join(dir, "main.js"), filepath.Join(dir, "m.js")
+	writeScript(t, m, "exports.v = 1")
+	writeScript(t, main, "var v = require('./m').v")
+
+	c := NewScriptCache(ScriptCacheOptions{Watch: true, PollInterval: time.Hour})
+	defer c.Close()
+	c.watcher = &failingWatcher{fileWatcher: c.watcher, fail: m}
+	if _, _, err := c.Load(main, nil); err != nil {
+		t.Fatal(err)
+	}
+	infos := c.Info(main)
+	if len(infos) != 1 || infos[0].WatchError == nil || !strings.Contains(infos[0].WatchError.Error(), m) {
+		t.Fatalf("Info() = %+v, want the watch error of %s", infos, m)
+	}
+
+	// the module not watched is checked by Load()
+	time.Sleep(10 * time.Millisecond)
+	writeScript(t, m, "exports.v = 22")
+	ctx, existing, err := c.Load(main, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ctx.GetGlobal("v"); existing || err != nil || res != float64(22) {
+		t.Fatalf("v = %v, %v after the module changed, existing: %v", res, err, existing)
+	}
+}
+
+func TestScriptCacheTinyTTL(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.js")
+	writeScript(t, a, "function f() { return 'a' }")
+
+	c := NewScriptCache(ScriptCacheOptions{TTL: time.Nanosecond})
+	defer c.Close()
+	ref, err := c.Acquire(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref.Release()
+	time.Sleep(5 * minReapInterval)
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 0 {
+		t.Fatalf("Stats() = %+v, want the expired context evicted", stats)
+	}
+}
+
+func TestScriptCacheCloseLoaded(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.js"), filepath.Join(dir, "b.js")
+	writeScript(t, a, "function f() { return 'a' }")
+	writeScript(t, b, "function f() { return 'b' }")
+
+	c := NewScriptCache(ScriptCacheOptions{MaxEntries: 1, CloseLoaded: true})
+	ctxA, _, err := c.Load(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := c.Acquire(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxB, _, err := c.Load(b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the evicted context is closed after the last ref released
+	if !ctxA.isOpen() {
+		t.Fatal("the evicted context is closed while acquired")
+	}
+	ref.Release()
+	if ctxA.isOpen() {
+		t.Fatal("the evicted context returned by Load() is not closed")
+	}
+	c.Close()
+	if ctxB.isOpen() {
+		t.Fatal("the context returned by Load() is not closed with the cache")
+	}
+}
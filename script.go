@@ -0,0 +1,228 @@
+package djs
+
+/*
+#include <string.h>
+#include "duktape.h"
+static duk_int_t pCompile(duk_context *ctx, const char *src, duk_size_t len, const char *filename, duk_size_t filenameLen) {
+	if (filenameLen == 0) {
+		return duk_pcompile_lstring(ctx, 0, src, len);
+	}
+	duk_push_lstring(ctx, filename, filenameLen);
+	return duk_pcompile_lstring_filename(ctx, 0, src, len);
+}
+static duk_ret_t loadFunction(duk_context *ctx, void *udata) {
+	(void) udata;
+	duk_load_function(ctx);
+	return 1;
+}
+static duk_int_t pLoad(duk_context *ctx, const char *bytecode, duk_size_t len) {
+	void *buf = duk_push_fixed_buffer(ctx, len);
+	memcpy(buf, bytecode, len);
+	return duk_safe_call(ctx, loadFunction, NULL, 1, 1);
+}
+*/
+import "C"
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+)
+
+// Script is a program compiled once and run many times in a context.
+type Script struct {
+	ctx *JsContext
+	idx uint32
+	bytecode []byte
+}
+
+var scriptIdx uint32
+
+// Compile compiles source to a Script which can be run many times in the context.
+// filename is used in error messages and stack traces.
+func (ctx *JsContext) Compile(source, filename string) (script *Script, err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	c := ctx.c
+
+	var src, name *C.char
+	var srcLen, nameLen C.int
+	getStrPtrLen(&source, &src, &srcLen)
+	getStrPtrLen(&filename, &name, &nameLen)
+	restore := limitMemory(c, true)
+	rc := C.pCompile(c, src, C.size_t(srcLen), name, C.size_t(nameLen))
+	restore()
+	if rc != 0 { // [ error ]
+		err = memoryLimitError(c, getJsError(c))
+		C.duk_pop(c) // [ ]
+		return
+	}
+	// [ function ]
+
+	C.duk_dup(c, -1) // [ function function ]
+	C.duk_dump_function(c) // [ function bytecode ]
+	var length C.size_t
+	b := C.duk_get_buffer(c, -1, &length)
+	bytecode := C.GoBytes(b, C.int(length))
+	C.duk_pop(c) // [ function ]
+
+	return newScript(ctx, bytecode), nil
+}
+
+// LoadScript loads the bytecode got from Script.Bytecode() to the context,
+// so that a compiled Script can be reused across contexts and persisted.
+// Duktape doesn't validate bytecode, and loading malformed or malicious bytecode
+// may corrupt the memory. Only load the bytecode produced by the same build of
+// this package from trusted sources.
+func LoadScript(ctx *JsContext, bytecode []byte) (script *Script, err error) {
+	if len(bytecode) == 0 {
+		err = fmt.Errorf("empty bytecode")
+		return
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	c := ctx.c
+
+	var b *C.char
+	var length C.int
+	getBytesPtrLen(bytecode, &b, &length)
+	restore := limitMemory(c, true)
+	rc := C.pLoad(c, b, C.size_t(length))
+	restore()
+	if rc != 0 { // [ error ]
+		err = memoryLimitError(c, getJsError(c))
+		C.duk_pop(c) // [ ]
+		return
+	}
+	// [ function ]
+
+	bc := make([]byte, len(bytecode))
+	copy(bc, bytecode)
+	return newScript(ctx, bc), nil
+}
+
+// newScript saves the function at the stack top to the stash. ctx.mu must be held.
+func newScript(ctx *JsContext, bytecode []byte) *Script {
+	c := ctx.c
+	// [ function ]
+	idx := atomic.AddUint32(&scriptIdx, 1)
+	pushScripts(c) // [ function scripts ]
+	C.duk_swap_top(c, -2) // [ scripts function ]
+	C.duk_put_prop_index(c, -2, C.duk_uarridx_t(idx)) // [ scripts ] with scripts[idx] = function
+	C.duk_pop(c) // [ ]
+
+	script := &Script{
+		ctx: ctx,
+		idx: idx,
+		bytecode: bytecode,
+	}
+	runtime.SetFinalizer(script, freeScript)
+	return script
+}
+
+// pushScripts pushes the object saving all scripts in the global stash.
+func pushScripts(ctx *C.duk_context) {
+	var name *C.char
+	getStrPtr(&scriptsName, &name)
+
+	C.duk_push_global_stash(ctx) // [ stash ]
+	if C.duk_get_prop_string(ctx, -1, name) == 0 { // [ stash scripts/undefined ]
+		C.duk_pop(ctx) // [ stash ]
+		C.duk_push_bare_object(ctx) // [ stash scripts ]
+		C.duk_dup(ctx, -1) // [ stash scripts scripts ]
+		C.duk_put_prop_string(ctx, -3, name) // [ stash scripts ] with stash[scriptsName] = scripts
+	}
+	C.duk_remove(ctx, -2) // [ scripts ]
+}
+
+func freeScript(script *Script) {
+	script.Close()
+}
+
+// Bytecode returns a copy of the bytecode of the Script, which can be loaded by LoadScript().
+func (script *Script) Bytecode() []byte {
+	bytecode := make([]byte, len(script.bytecode))
+	copy(bytecode, script.bytecode)
+	return bytecode
+}
+
+// Run runs the Script with env set as global vars.
+func (script *Script) Run(env map[string]interface{}) (res interface{}, err error) {
+	return script.RunContext(context.Background(), env)
+}
+
+// RunContext is the same as Run, but the script is aborted with a *TimeoutError
+// when goCtx is cancelled or its deadline passes.
+func (script *Script) RunContext(goCtx context.Context, env map[string]interface{}) (res interface{}, err error) {
+	ctx := script.ctx
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if err = ctx.checkOpen(); err != nil {
+		return
+	}
+	if script.idx == 0 {
+		err = fmt.Errorf("script closed")
+		return
+	}
+	c := ctx.c
+	if err = setEnv(c, env); err != nil {
+		return
+	}
+	if err = checkMemoryLimit(c); err != nil {
+		return
+	}
+
+	goCtx, cancel := ctx.execContext(goCtx)
+	defer cancel()
+	stop, err := ctx.watchInterrupt(goCtx)
+	if err != nil {
+		return
+	}
+
+	pushScripts(c) // [ scripts ]
+	defer C.duk_pop_n(c, 2) // [ ]
+	C.duk_get_prop_index(c, -1, C.duk_uarridx_t(script.idx)) // [ scripts function ]
+	restore := limitMemory(c, true)
+	rc := C.duk_pcall(c, 0)
+	restore()
+	if rc != 0 { // [ scripts error ]
+		err = stop(memoryLimitError(c, getJsError(c)))
+		return
+	}
+	stop(nil)
+	// [ scripts result ]
+	return fromJsValue(c)
+}
+
+// Close releases the compiled function from the context. The bytecode is still available.
+func (script *Script) Close() {
+	ctx := script.ctx
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if script.idx == 0 {
+		return
+	}
+	idx := script.idx
+	script.idx = 0
+	runtime.SetFinalizer(script, nil)
+	if ctx.checkOpen() != nil {
+		return
+	}
+
+	c := ctx.c
+	pushScripts(c) // [ scripts ]
+	C.duk_del_prop_index(c, -1, C.duk_uarridx_t(idx)) // [ scripts ]
+	C.duk_pop(c) // [ ]
+}
+
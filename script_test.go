@@ -0,0 +1,35 @@
+package djs
+
+import (
+	"testing"
+)
+
+func TestScriptBytecodeCopy(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	script, err := ctx.Compile("a * 2", "expr.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := script.Bytecode()
+	for i := range b {
+		b[i] = 0
+	}
+
+	ctx2, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx2.Close()
+	script2, err := LoadScript(ctx2, script.Bytecode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := script2.Run(map[string]interface{}{"a": 10}); err != nil || res != float64(20) {
+		t.Fatalf("Run() = %v, %v", res, err)
+	}
+}
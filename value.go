@@ -43,8 +43,7 @@ func fromJsValue(ctx *C.duk_context) (goVal interface{}, err error) {
 			goVal = toBytes(b, int(length))
 			return
 		case C.duk_get_error_code(ctx, -1) != 0:
-			s := C.duk_safe_to_lstring(ctx, -1, &length)
-			err = fmt.Errorf(*(toString(s, int(length))))
+			err = getJsError(ctx)
 			return
 		case C.duk_is_array(ctx, -1) != 0:
 			// array